@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Analysis is the static analysis of a Lox source used by the tooling
+// commands: its tokens, syntax tree and errors, with the declarations and
+// the uses of names found by the resolver.
+type Analysis struct {
+	src        string
+	Tokens     []Token
+	Comments   []Comment
+	Statements []Stmt
+	Problems   []Problem
+	Decls      []*Declaration
+	Uses       []Use
+
+	globals map[string]*Declaration
+	closing map[int]int // token index of each { to the index of its }
+}
+
+// Problem is an error found in a source, located by byte offsets.
+type Problem struct {
+	Offset int
+	Length int
+	Row    int
+	Msg    string
+}
+
+// Use is the use of a variable. Decl is nil for names which are not
+// declared by the source, such as natives.
+type Use struct {
+	Name   Token
+	Decl   *Declaration
+	Assign bool
+}
+
+var _ ResolveListener = &Analysis{}
+
+// Analyze scans, parses and resolves src. Analysis stops after the scanner
+// if it reports errors, the statements parsed despite syntax errors are
+// still resolved.
+func Analyze(src string) *Analysis {
+	a := &Analysis{
+		src:     src,
+		globals: make(map[string]*Declaration),
+		closing: make(map[int]int),
+	}
+
+	scanner := NewScanner(src)
+	tokens, scanErrs := scanner.Scan()
+	a.Tokens = tokens
+	a.Comments = scanner.Comments()
+	a.matchBraces()
+	if len(scanErrs) > 0 {
+		for _, err := range scanErrs {
+			a.Problems = append(a.Problems, Problem{Offset: err.Offset, Length: 1, Row: err.Row, Msg: err.Msg})
+		}
+		return a
+	}
+
+	statements, errs := NewParser(tokens).ParseAll()
+	for _, err := range errs {
+		a.addError(err)
+	}
+	a.Statements = statements
+
+	resolver := NewResolver()
+	resolver.SetListener(a)
+	resolver.Resolve(statements)
+	for _, err := range resolver.Errors() {
+		a.addError(err)
+	}
+
+	// globals may be used before their declaration
+	for n := range a.Uses {
+		if a.Uses[n].Decl == nil {
+			a.Uses[n].Decl = a.globals[a.Uses[n].Name.lexeme]
+		}
+	}
+	return a
+}
+
+// Position returns the line and column, from 1, of a byte offset.
+func (a *Analysis) Position(offset int) (line, col int) {
+	if offset > len(a.src) {
+		offset = len(a.src)
+	}
+	line = strings.Count(a.src[:offset], "\n") + 1
+	col = offset - strings.LastIndexByte(a.src[:offset], '\n')
+	return line, col
+}
+
+func (a *Analysis) addError(err *LoxError) {
+	span := err.Span()
+	a.Problems = append(a.Problems, Problem{
+		Offset: span.Start.Offset,
+		Length: span.Len(),
+		Row:    span.Start.Row,
+		Msg:    err.msg,
+	})
+}
+
+func (a *Analysis) Declare(decl *Declaration) {
+	a.Decls = append(a.Decls, decl)
+	if decl.Depth == 0 && decl.Kind != MethodDecl {
+		if _, ok := a.globals[decl.Name.lexeme]; !ok {
+			a.globals[decl.Name.lexeme] = decl
+		}
+	}
+}
+
+func (a *Analysis) Use(name Token, decl *Declaration, assign bool) {
+	a.Uses = append(a.Uses, Use{Name: name, Decl: decl, Assign: assign})
+}
+
+func (a *Analysis) matchBraces() {
+	open := make([]int, 0)
+	for n, token := range a.Tokens {
+		switch token.typ {
+		case LEFT_BRACE:
+			open = append(open, n)
+		case RIGHT_BRACE:
+			if len(open) > 0 {
+				a.closing[open[len(open)-1]] = n
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for _, n := range open {
+		a.closing[n] = len(a.Tokens) - 1
+	}
+}
+
+// tokenIndex returns the index of the token starting at offset, or -1.
+func (a *Analysis) tokenIndex(offset int) int {
+	n := sort.Search(len(a.Tokens), func(n int) bool { return a.Tokens[n].offset >= offset })
+	if n < len(a.Tokens) && a.Tokens[n].offset == offset {
+		return n
+	}
+	return -1
+}
+
+// NameAt returns the identifier token at offset, which may also be just
+// after its end.
+func (a *Analysis) NameAt(offset int) (Token, bool) {
+	for _, token := range a.Tokens {
+		if token.offset > offset {
+			break
+		}
+		if token.typ == IDENTIFIER && offset <= token.offset+len(token.lexeme) {
+			return token, true
+		}
+	}
+	return Token{}, false
+}
+
+// isProperty tells if the identifier token is the name of a property, as in
+// object.name.
+func (a *Analysis) isProperty(name Token) bool {
+	n := a.tokenIndex(name.offset)
+	return n > 0 && a.Tokens[n-1].typ == DOT
+}
+
+// Definitions returns the declarations the name at offset refers to. A
+// property may refer to the methods of any class with the same name.
+func (a *Analysis) Definitions(offset int) []*Declaration {
+	name, ok := a.NameAt(offset)
+	if !ok {
+		return nil
+	}
+	if a.isProperty(name) {
+		return a.methods(name.lexeme)
+	}
+	for _, decl := range a.Decls {
+		if decl.Name.offset == name.offset {
+			return []*Declaration{decl}
+		}
+	}
+	for _, use := range a.Uses {
+		if use.Name.offset == name.offset && use.Decl != nil {
+			return []*Declaration{use.Decl}
+		}
+	}
+	return nil
+}
+
+func (a *Analysis) methods(name string) []*Declaration {
+	decls := make([]*Declaration, 0)
+	for _, decl := range a.Decls {
+		if decl.Kind == MethodDecl && decl.Name.lexeme == name {
+			decls = append(decls, decl)
+		}
+	}
+	return decls
+}
+
+// References returns the uses of decl, and decl itself if includeDecl. The
+// references of a method are the properties with its name.
+func (a *Analysis) References(decl *Declaration, includeDecl bool) []Token {
+	refs := make([]Token, 0)
+	if includeDecl {
+		refs = append(refs, decl.Name)
+	}
+	if decl.Kind == MethodDecl {
+		for _, token := range a.Tokens {
+			if token.typ == IDENTIFIER && token.lexeme == decl.Name.lexeme && a.isProperty(token) {
+				refs = append(refs, token)
+			}
+		}
+		return refs
+	}
+	for _, use := range a.Uses {
+		if use.Decl == decl {
+			refs = append(refs, use.Name)
+		}
+	}
+	return refs
+}
+
+// Signature describes a declaration as it is written in the source.
+func Signature(decl *Declaration) string {
+	switch decl.Kind {
+	case FunDecl, MethodDecl:
+		fun := decl.Node.(*StmtFun)
+		params := make([]string, 0, len(fun.Params)+1)
+		for n, param := range fun.Params {
+			if fun.Defaults[n] != nil {
+				params = append(params, param.lexeme+" = …")
+			} else {
+				params = append(params, param.lexeme)
+			}
+		}
+		if fun.Rest != nil {
+			params = append(params, "..."+fun.Rest.lexeme)
+		}
+		keyword := "fun "
+		if decl.Kind == MethodDecl {
+			keyword = ""
+		}
+		return fmt.Sprintf("%s%s(%s)", keyword, fun.Name.lexeme, strings.Join(params, ", "))
+	case ClassDecl:
+		class := decl.Node.(*StmtClass)
+		sig := "class " + class.Name.lexeme
+		if class.Superclass != nil {
+			sig += " < " + class.Superclass.Name.lexeme
+		}
+		return sig
+	case TraitDecl:
+		return "trait " + decl.Name.lexeme
+	case ParamDecl:
+		return "parameter " + decl.Name.lexeme
+	}
+	return "var " + decl.Name.lexeme
+}
+
+// Arity describes the number of arguments a function declaration accepts.
+func Arity(fun *StmtFun) string {
+	min, max := declArity(fun)
+	switch {
+	case max < 0:
+		return fmt.Sprintf("%d or more", min)
+	case min != max:
+		return fmt.Sprintf("%d to %d", min, max)
+	}
+	return fmt.Sprintf("%d", min)
+}
+
+// DocComment returns the // comments on the lines right above a declaration.
+func (a *Analysis) DocComment(decl *Declaration) string {
+	lines := strings.Split(a.src[:decl.Name.offset], "\n")
+	// the declaration line itself may start with a keyword
+	lines = lines[:len(lines)-1]
+	doc := make([]string, 0)
+	for n := len(lines) - 1; n >= 0; n-- {
+		line := strings.TrimSpace(lines[n])
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		doc = append([]string{strings.TrimSpace(strings.TrimPrefix(line, "//"))}, doc...)
+	}
+	return strings.Join(doc, "\n")
+}
+
+// Visible returns the declarations in scope at offset, innermost first, with
+// a single declaration for each name.
+func (a *Analysis) Visible(offset int) []*Declaration {
+	seen := make(map[string]bool)
+	visible := make([]*Declaration, 0)
+	for _, locals := range []bool{true, false} {
+		for n := len(a.Decls) - 1; n >= 0; n-- {
+			decl := a.Decls[n]
+			if decl.Kind == MethodDecl || seen[decl.Name.lexeme] || (decl.Depth > 0) != locals {
+				continue
+			}
+			if locals && !a.inScope(decl, offset) {
+				continue
+			}
+			seen[decl.Name.lexeme] = true
+			visible = append(visible, decl)
+		}
+	}
+	return visible
+}
+
+// inScope tells if the local decl is visible at offset: after the
+// declaration and before the closing brace of its block. The scope of a
+// parameter is the body of its function.
+func (a *Analysis) inScope(decl *Declaration, offset int) bool {
+	if offset <= decl.Name.offset {
+		return false
+	}
+	n := a.tokenIndex(decl.Name.offset)
+	if n < 0 {
+		return false
+	}
+	if decl.Kind == ParamDecl {
+		for n < len(a.Tokens) && a.Tokens[n].typ != LEFT_BRACE {
+			n++
+		}
+		if n == len(a.Tokens) {
+			return false
+		}
+		return offset <= a.Tokens[a.closing[n]].offset
+	}
+	depth := 0
+	for ; n >= 0; n-- {
+		switch a.Tokens[n].typ {
+		case RIGHT_BRACE:
+			depth++
+		case LEFT_BRACE:
+			if depth == 0 {
+				return offset <= a.Tokens[a.closing[n]].offset
+			}
+			depth--
+		}
+	}
+	return true
+}
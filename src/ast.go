@@ -1,8 +1,15 @@
 package main
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type Expr interface {
 	Type() ExprType
 	Accept(ExprVisitor) (interface{}, error)
+	Span() Span
+	SetSpan(Span)
 }
 
 type ExprType int32
@@ -21,6 +28,8 @@ const (
 	ExprTypeSet
 	ExprTypeThis
 	ExprTypeSuper
+	ExprTypeIndex
+	ExprTypeSpread
 )
 
 type ExprVisitor interface {
@@ -36,9 +45,12 @@ type ExprVisitor interface {
 	VisitSet(*ExprSet) (interface{}, error)
 	VisitThis(*ExprThis) (interface{}, error)
 	VisitSuper(*ExprSuper) (interface{}, error)
+	VisitIndex(*ExprIndex) (interface{}, error)
+	VisitSpread(*ExprSpread) (interface{}, error)
 }
 
 type ExprLiteral struct {
+	nodeSpan
 	Value interface{}
 }
 
@@ -51,6 +63,7 @@ func (node *ExprLiteral) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprVariable struct {
+	nodeSpan
 	Name Token
 }
 
@@ -63,6 +76,7 @@ func (node *ExprVariable) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprAssign struct {
+	nodeSpan
 	Name  Token
 	Value Expr
 }
@@ -76,6 +90,7 @@ func (node *ExprAssign) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprUnary struct {
+	nodeSpan
 	UnaryOperator Token
 	Expression    Expr
 }
@@ -89,6 +104,7 @@ func (node *ExprUnary) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprGrouping struct {
+	nodeSpan
 	Expression Expr
 }
 
@@ -101,6 +117,7 @@ func (node *ExprGrouping) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprBinary struct {
+	nodeSpan
 	Left     Expr
 	Operator Token
 	Right    Expr
@@ -115,6 +132,7 @@ func (node *ExprBinary) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprLogical struct {
+	nodeSpan
 	Left     Expr
 	Operator Token
 	Right    Expr
@@ -129,6 +147,7 @@ func (node *ExprLogical) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprCall struct {
+	nodeSpan
 	Callee Expr
 	Paren  Token
 	Args   []Expr
@@ -143,6 +162,7 @@ func (node *ExprCall) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprGet struct {
+	nodeSpan
 	Object Expr
 	Field  Token
 	Dot    Token
@@ -157,6 +177,7 @@ func (node *ExprGet) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprSet struct {
+	nodeSpan
 	Object Expr
 	Field  Token
 	Value  Expr
@@ -172,6 +193,7 @@ func (node *ExprSet) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprThis struct {
+	nodeSpan
 	Keyword Token
 }
 
@@ -184,6 +206,7 @@ func (node *ExprThis) Accept(v ExprVisitor) (interface{}, error) {
 }
 
 type ExprSuper struct {
+	nodeSpan
 	Keyword Token
 	Method  Token
 }
@@ -196,9 +219,300 @@ func (node *ExprSuper) Accept(v ExprVisitor) (interface{}, error) {
 	return v.VisitSuper(node)
 }
 
+type ExprIndex struct {
+	nodeSpan
+	Object  Expr
+	Bracket Token
+	Index   Expr
+}
+
+func (node *ExprIndex) Type() ExprType {
+	return ExprTypeIndex
+}
+
+func (node *ExprIndex) Accept(v ExprVisitor) (interface{}, error) {
+	return v.VisitIndex(node)
+}
+
+type ExprSpread struct {
+	nodeSpan
+	Ellipsis   Token
+	Expression Expr
+}
+
+func (node *ExprSpread) Type() ExprType {
+	return ExprTypeSpread
+}
+
+func (node *ExprSpread) Accept(v ExprVisitor) (interface{}, error) {
+	return v.VisitSpread(node)
+}
+
+func (node *ExprLiteral) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprLiteral", node.Span())
+	obj.add("value", node.Value)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprVariable) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprVariable", node.Span())
+	obj.add("name", node.Name)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprAssign) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprAssign", node.Span())
+	obj.add("name", node.Name)
+	obj.add("value", node.Value)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprUnary) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprUnary", node.Span())
+	obj.add("unaryOperator", node.UnaryOperator)
+	obj.add("expression", node.Expression)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprGrouping) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprGrouping", node.Span())
+	obj.add("expression", node.Expression)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprBinary) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprBinary", node.Span())
+	obj.add("left", node.Left)
+	obj.add("operator", node.Operator)
+	obj.add("right", node.Right)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprLogical) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprLogical", node.Span())
+	obj.add("left", node.Left)
+	obj.add("operator", node.Operator)
+	obj.add("right", node.Right)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprCall) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprCall", node.Span())
+	obj.add("callee", node.Callee)
+	obj.add("paren", node.Paren)
+	obj.add("args", node.Args)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprGet) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprGet", node.Span())
+	obj.add("object", node.Object)
+	obj.add("field", node.Field)
+	obj.add("dot", node.Dot)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprSet) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprSet", node.Span())
+	obj.add("object", node.Object)
+	obj.add("field", node.Field)
+	obj.add("value", node.Value)
+	obj.add("dot", node.Dot)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprThis) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprThis", node.Span())
+	obj.add("keyword", node.Keyword)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprSuper) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprSuper", node.Span())
+	obj.add("keyword", node.Keyword)
+	obj.add("method", node.Method)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprIndex) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprIndex", node.Span())
+	obj.add("object", node.Object)
+	obj.add("bracket", node.Bracket)
+	obj.add("index", node.Index)
+	return obj.MarshalJSON()
+}
+
+func (node *ExprSpread) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("ExprSpread", node.Span())
+	obj.add("ellipsis", node.Ellipsis)
+	obj.add("expression", node.Expression)
+	return obj.MarshalJSON()
+}
+
+func decodeExpr(data json.RawMessage) (Expr, error) {
+	kind, span, fields, err := decodeJSONNode(data)
+	if err != nil || kind == "" {
+		return nil, err
+	}
+	switch kind {
+	case "ExprLiteral":
+		node := &ExprLiteral{}
+		node.SetSpan(span)
+		if node.Value, err = decodeValue(fields["value"]); err != nil {
+			return nil, fmt.Errorf("ExprLiteral.value: %s", err)
+		}
+		return node, nil
+	case "ExprVariable":
+		node := &ExprVariable{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("ExprVariable.name: %s", err)
+		}
+		return node, nil
+	case "ExprAssign":
+		node := &ExprAssign{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("ExprAssign.name: %s", err)
+		}
+		if node.Value, err = decodeExpr(fields["value"]); err != nil {
+			return nil, fmt.Errorf("ExprAssign.value: %s", err)
+		}
+		return node, nil
+	case "ExprUnary":
+		node := &ExprUnary{}
+		node.SetSpan(span)
+		if node.UnaryOperator, err = decodeToken(fields["unaryOperator"]); err != nil {
+			return nil, fmt.Errorf("ExprUnary.unaryOperator: %s", err)
+		}
+		if node.Expression, err = decodeExpr(fields["expression"]); err != nil {
+			return nil, fmt.Errorf("ExprUnary.expression: %s", err)
+		}
+		return node, nil
+	case "ExprGrouping":
+		node := &ExprGrouping{}
+		node.SetSpan(span)
+		if node.Expression, err = decodeExpr(fields["expression"]); err != nil {
+			return nil, fmt.Errorf("ExprGrouping.expression: %s", err)
+		}
+		return node, nil
+	case "ExprBinary":
+		node := &ExprBinary{}
+		node.SetSpan(span)
+		if node.Left, err = decodeExpr(fields["left"]); err != nil {
+			return nil, fmt.Errorf("ExprBinary.left: %s", err)
+		}
+		if node.Operator, err = decodeToken(fields["operator"]); err != nil {
+			return nil, fmt.Errorf("ExprBinary.operator: %s", err)
+		}
+		if node.Right, err = decodeExpr(fields["right"]); err != nil {
+			return nil, fmt.Errorf("ExprBinary.right: %s", err)
+		}
+		return node, nil
+	case "ExprLogical":
+		node := &ExprLogical{}
+		node.SetSpan(span)
+		if node.Left, err = decodeExpr(fields["left"]); err != nil {
+			return nil, fmt.Errorf("ExprLogical.left: %s", err)
+		}
+		if node.Operator, err = decodeToken(fields["operator"]); err != nil {
+			return nil, fmt.Errorf("ExprLogical.operator: %s", err)
+		}
+		if node.Right, err = decodeExpr(fields["right"]); err != nil {
+			return nil, fmt.Errorf("ExprLogical.right: %s", err)
+		}
+		return node, nil
+	case "ExprCall":
+		node := &ExprCall{}
+		node.SetSpan(span)
+		if node.Callee, err = decodeExpr(fields["callee"]); err != nil {
+			return nil, fmt.Errorf("ExprCall.callee: %s", err)
+		}
+		if node.Paren, err = decodeToken(fields["paren"]); err != nil {
+			return nil, fmt.Errorf("ExprCall.paren: %s", err)
+		}
+		if node.Args, err = decodeExprList(fields["args"]); err != nil {
+			return nil, fmt.Errorf("ExprCall.args: %s", err)
+		}
+		return node, nil
+	case "ExprGet":
+		node := &ExprGet{}
+		node.SetSpan(span)
+		if node.Object, err = decodeExpr(fields["object"]); err != nil {
+			return nil, fmt.Errorf("ExprGet.object: %s", err)
+		}
+		if node.Field, err = decodeToken(fields["field"]); err != nil {
+			return nil, fmt.Errorf("ExprGet.field: %s", err)
+		}
+		if node.Dot, err = decodeToken(fields["dot"]); err != nil {
+			return nil, fmt.Errorf("ExprGet.dot: %s", err)
+		}
+		return node, nil
+	case "ExprSet":
+		node := &ExprSet{}
+		node.SetSpan(span)
+		if node.Object, err = decodeExpr(fields["object"]); err != nil {
+			return nil, fmt.Errorf("ExprSet.object: %s", err)
+		}
+		if node.Field, err = decodeToken(fields["field"]); err != nil {
+			return nil, fmt.Errorf("ExprSet.field: %s", err)
+		}
+		if node.Value, err = decodeExpr(fields["value"]); err != nil {
+			return nil, fmt.Errorf("ExprSet.value: %s", err)
+		}
+		if node.Dot, err = decodeToken(fields["dot"]); err != nil {
+			return nil, fmt.Errorf("ExprSet.dot: %s", err)
+		}
+		return node, nil
+	case "ExprThis":
+		node := &ExprThis{}
+		node.SetSpan(span)
+		if node.Keyword, err = decodeToken(fields["keyword"]); err != nil {
+			return nil, fmt.Errorf("ExprThis.keyword: %s", err)
+		}
+		return node, nil
+	case "ExprSuper":
+		node := &ExprSuper{}
+		node.SetSpan(span)
+		if node.Keyword, err = decodeToken(fields["keyword"]); err != nil {
+			return nil, fmt.Errorf("ExprSuper.keyword: %s", err)
+		}
+		if node.Method, err = decodeToken(fields["method"]); err != nil {
+			return nil, fmt.Errorf("ExprSuper.method: %s", err)
+		}
+		return node, nil
+	case "ExprIndex":
+		node := &ExprIndex{}
+		node.SetSpan(span)
+		if node.Object, err = decodeExpr(fields["object"]); err != nil {
+			return nil, fmt.Errorf("ExprIndex.object: %s", err)
+		}
+		if node.Bracket, err = decodeToken(fields["bracket"]); err != nil {
+			return nil, fmt.Errorf("ExprIndex.bracket: %s", err)
+		}
+		if node.Index, err = decodeExpr(fields["index"]); err != nil {
+			return nil, fmt.Errorf("ExprIndex.index: %s", err)
+		}
+		return node, nil
+	case "ExprSpread":
+		node := &ExprSpread{}
+		node.SetSpan(span)
+		if node.Ellipsis, err = decodeToken(fields["ellipsis"]); err != nil {
+			return nil, fmt.Errorf("ExprSpread.ellipsis: %s", err)
+		}
+		if node.Expression, err = decodeExpr(fields["expression"]); err != nil {
+			return nil, fmt.Errorf("ExprSpread.expression: %s", err)
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("unknown expr kind %q", kind)
+}
+
 type Stmt interface {
 	Type() StmtType
 	Accept(StmtVisitor) (interface{}, error)
+	Span() Span
+	SetSpan(Span)
 }
 
 type StmtType int32
@@ -214,6 +528,7 @@ const (
 	StmtTypeFun
 	StmtTypeReturn
 	StmtTypeClass
+	StmtTypeTrait
 )
 
 type StmtVisitor interface {
@@ -226,9 +541,11 @@ type StmtVisitor interface {
 	VisitFun(*StmtFun) (interface{}, error)
 	VisitReturn(*StmtReturn) (interface{}, error)
 	VisitClass(*StmtClass) (interface{}, error)
+	VisitTrait(*StmtTrait) (interface{}, error)
 }
 
 type StmtExpression struct {
+	nodeSpan
 	Expression Expr
 }
 
@@ -241,6 +558,8 @@ func (node *StmtExpression) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtPrint struct {
+	nodeSpan
+	Keyword    Token
 	Expression Expr
 }
 
@@ -253,6 +572,7 @@ func (node *StmtPrint) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtVar struct {
+	nodeSpan
 	Name        Token
 	Initializer Expr
 }
@@ -266,6 +586,7 @@ func (node *StmtVar) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtBlock struct {
+	nodeSpan
 	Statements []Stmt
 }
 
@@ -278,6 +599,7 @@ func (node *StmtBlock) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtIf struct {
+	nodeSpan
 	Cond Expr
 	Then Stmt
 	Else Stmt
@@ -292,8 +614,10 @@ func (node *StmtIf) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtWhile struct {
-	Cond Expr
-	Body Stmt
+	nodeSpan
+	Keyword Token
+	Cond    Expr
+	Body    Stmt
 }
 
 func (node *StmtWhile) Type() StmtType {
@@ -305,9 +629,12 @@ func (node *StmtWhile) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtFun struct {
-	Name   string
-	Params []string
-	Body   []Stmt
+	nodeSpan
+	Name     Token
+	Params   []Token
+	Defaults []Expr
+	Rest     *Token
+	Body     []Stmt
 }
 
 func (node *StmtFun) Type() StmtType {
@@ -319,6 +646,7 @@ func (node *StmtFun) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtReturn struct {
+	nodeSpan
 	Keyword Token
 	Value   Expr
 }
@@ -332,9 +660,14 @@ func (node *StmtReturn) Accept(v StmtVisitor) (interface{}, error) {
 }
 
 type StmtClass struct {
-	Name       string
-	Superclass *ExprVariable
-	Methods    []*StmtFun
+	nodeSpan
+	Name          Token
+	Superclass    *ExprVariable
+	Traits        []*ExprVariable
+	Methods       []*StmtFun
+	StaticMethods []*StmtFun
+	Getters       []*StmtFun
+	Setters       []*StmtFun
 }
 
 func (node *StmtClass) Type() StmtType {
@@ -344,3 +677,229 @@ func (node *StmtClass) Type() StmtType {
 func (node *StmtClass) Accept(v StmtVisitor) (interface{}, error) {
 	return v.VisitClass(node)
 }
+
+type StmtTrait struct {
+	nodeSpan
+	Name    Token
+	Methods []*StmtFun
+}
+
+func (node *StmtTrait) Type() StmtType {
+	return StmtTypeTrait
+}
+
+func (node *StmtTrait) Accept(v StmtVisitor) (interface{}, error) {
+	return v.VisitTrait(node)
+}
+
+func (node *StmtExpression) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtExpression", node.Span())
+	obj.add("expression", node.Expression)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtPrint) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtPrint", node.Span())
+	obj.add("keyword", node.Keyword)
+	obj.add("expression", node.Expression)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtVar) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtVar", node.Span())
+	obj.add("name", node.Name)
+	obj.add("initializer", node.Initializer)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtBlock) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtBlock", node.Span())
+	obj.add("statements", node.Statements)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtIf) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtIf", node.Span())
+	obj.add("cond", node.Cond)
+	obj.add("then", node.Then)
+	obj.add("else", node.Else)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtWhile) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtWhile", node.Span())
+	obj.add("keyword", node.Keyword)
+	obj.add("cond", node.Cond)
+	obj.add("body", node.Body)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtFun) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtFun", node.Span())
+	obj.add("name", node.Name)
+	obj.add("params", node.Params)
+	obj.add("defaults", node.Defaults)
+	obj.add("rest", node.Rest)
+	obj.add("body", node.Body)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtReturn) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtReturn", node.Span())
+	obj.add("keyword", node.Keyword)
+	obj.add("value", node.Value)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtClass) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtClass", node.Span())
+	obj.add("name", node.Name)
+	obj.add("superclass", node.Superclass)
+	obj.add("traits", node.Traits)
+	obj.add("methods", node.Methods)
+	obj.add("staticMethods", node.StaticMethods)
+	obj.add("getters", node.Getters)
+	obj.add("setters", node.Setters)
+	return obj.MarshalJSON()
+}
+
+func (node *StmtTrait) MarshalJSON() ([]byte, error) {
+	obj := newJSONNode("StmtTrait", node.Span())
+	obj.add("name", node.Name)
+	obj.add("methods", node.Methods)
+	return obj.MarshalJSON()
+}
+
+func decodeStmt(data json.RawMessage) (Stmt, error) {
+	kind, span, fields, err := decodeJSONNode(data)
+	if err != nil || kind == "" {
+		return nil, err
+	}
+	switch kind {
+	case "StmtExpression":
+		node := &StmtExpression{}
+		node.SetSpan(span)
+		if node.Expression, err = decodeExpr(fields["expression"]); err != nil {
+			return nil, fmt.Errorf("StmtExpression.expression: %s", err)
+		}
+		return node, nil
+	case "StmtPrint":
+		node := &StmtPrint{}
+		node.SetSpan(span)
+		if node.Keyword, err = decodeToken(fields["keyword"]); err != nil {
+			return nil, fmt.Errorf("StmtPrint.keyword: %s", err)
+		}
+		if node.Expression, err = decodeExpr(fields["expression"]); err != nil {
+			return nil, fmt.Errorf("StmtPrint.expression: %s", err)
+		}
+		return node, nil
+	case "StmtVar":
+		node := &StmtVar{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("StmtVar.name: %s", err)
+		}
+		if node.Initializer, err = decodeExpr(fields["initializer"]); err != nil {
+			return nil, fmt.Errorf("StmtVar.initializer: %s", err)
+		}
+		return node, nil
+	case "StmtBlock":
+		node := &StmtBlock{}
+		node.SetSpan(span)
+		if node.Statements, err = decodeStmtList(fields["statements"]); err != nil {
+			return nil, fmt.Errorf("StmtBlock.statements: %s", err)
+		}
+		return node, nil
+	case "StmtIf":
+		node := &StmtIf{}
+		node.SetSpan(span)
+		if node.Cond, err = decodeExpr(fields["cond"]); err != nil {
+			return nil, fmt.Errorf("StmtIf.cond: %s", err)
+		}
+		if node.Then, err = decodeStmt(fields["then"]); err != nil {
+			return nil, fmt.Errorf("StmtIf.then: %s", err)
+		}
+		if node.Else, err = decodeStmt(fields["else"]); err != nil {
+			return nil, fmt.Errorf("StmtIf.else: %s", err)
+		}
+		return node, nil
+	case "StmtWhile":
+		node := &StmtWhile{}
+		node.SetSpan(span)
+		if node.Keyword, err = decodeToken(fields["keyword"]); err != nil {
+			return nil, fmt.Errorf("StmtWhile.keyword: %s", err)
+		}
+		if node.Cond, err = decodeExpr(fields["cond"]); err != nil {
+			return nil, fmt.Errorf("StmtWhile.cond: %s", err)
+		}
+		if node.Body, err = decodeStmt(fields["body"]); err != nil {
+			return nil, fmt.Errorf("StmtWhile.body: %s", err)
+		}
+		return node, nil
+	case "StmtFun":
+		node := &StmtFun{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("StmtFun.name: %s", err)
+		}
+		if node.Params, err = decodeTokenList(fields["params"]); err != nil {
+			return nil, fmt.Errorf("StmtFun.params: %s", err)
+		}
+		if node.Defaults, err = decodeExprList(fields["defaults"]); err != nil {
+			return nil, fmt.Errorf("StmtFun.defaults: %s", err)
+		}
+		if node.Rest, err = decodeTokenPtr(fields["rest"]); err != nil {
+			return nil, fmt.Errorf("StmtFun.rest: %s", err)
+		}
+		if node.Body, err = decodeStmtList(fields["body"]); err != nil {
+			return nil, fmt.Errorf("StmtFun.body: %s", err)
+		}
+		return node, nil
+	case "StmtReturn":
+		node := &StmtReturn{}
+		node.SetSpan(span)
+		if node.Keyword, err = decodeToken(fields["keyword"]); err != nil {
+			return nil, fmt.Errorf("StmtReturn.keyword: %s", err)
+		}
+		if node.Value, err = decodeExpr(fields["value"]); err != nil {
+			return nil, fmt.Errorf("StmtReturn.value: %s", err)
+		}
+		return node, nil
+	case "StmtClass":
+		node := &StmtClass{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.name: %s", err)
+		}
+		if node.Superclass, err = decodeVariable(fields["superclass"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.superclass: %s", err)
+		}
+		if node.Traits, err = decodeVariableList(fields["traits"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.traits: %s", err)
+		}
+		if node.Methods, err = decodeFunList(fields["methods"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.methods: %s", err)
+		}
+		if node.StaticMethods, err = decodeFunList(fields["staticMethods"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.staticMethods: %s", err)
+		}
+		if node.Getters, err = decodeFunList(fields["getters"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.getters: %s", err)
+		}
+		if node.Setters, err = decodeFunList(fields["setters"]); err != nil {
+			return nil, fmt.Errorf("StmtClass.setters: %s", err)
+		}
+		return node, nil
+	case "StmtTrait":
+		node := &StmtTrait{}
+		node.SetSpan(span)
+		if node.Name, err = decodeToken(fields["name"]); err != nil {
+			return nil, fmt.Errorf("StmtTrait.name: %s", err)
+		}
+		if node.Methods, err = decodeFunList(fields["methods"]); err != nil {
+			return nil, fmt.Errorf("StmtTrait.methods: %s", err)
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("unknown stmt kind %q", kind)
+}
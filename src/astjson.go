@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The JSON of a node is an object with its kind, the name of its type, its
+// span, and its fields, named as in Go with a lower case first letter. The
+// codecs of the nodes are generated by astgen in ast.go, from the functions
+// of this file.
+
+// jsonNode is the JSON object of a node, keeping its fields in order.
+type jsonNode struct {
+	keys   []string
+	values []interface{}
+}
+
+func newJSONNode(kind string, span Span) *jsonNode {
+	obj := &jsonNode{}
+	obj.add("kind", kind)
+	obj.add("span", span)
+	return obj
+}
+
+func (obj *jsonNode) add(key string, value interface{}) {
+	obj.keys = append(obj.keys, key)
+	obj.values = append(obj.values, value)
+}
+
+func (obj *jsonNode) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for n, key := range obj.keys {
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(obj.values[n])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// jsonToken is the JSON of a token, its value being the value of a literal
+// and null for the other tokens.
+type jsonToken struct {
+	Type   string      `json:"type"`
+	Lexeme string      `json:"lexeme"`
+	Value  interface{} `json:"value"`
+	Row    int         `json:"row"`
+	Col    int         `json:"col"`
+	Offset int         `json:"offset"`
+}
+
+func (token Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonToken{
+		Type:   token.typ.String(),
+		Lexeme: token.lexeme,
+		Value:  token.literal(),
+		Row:    token.row,
+		Col:    token.col,
+		Offset: token.offset,
+	})
+}
+
+// literal returns the value of a literal token, nil for the others.
+func (token Token) literal() interface{} {
+	switch token.typ {
+	case NUMBER, STRING, TRUE, FALSE:
+		return token.lexval
+	}
+	return nil
+}
+
+// tokenTypes are the token types by name.
+var tokenTypes = func() map[string]TokenType {
+	types := make(map[string]TokenType)
+	for t := EOF; t.String() != ""; t++ {
+		types[t.String()] = t
+	}
+	return types
+}()
+
+func isJSONNull(data json.RawMessage) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) == 0 || string(data) == "null"
+}
+
+// decodeJSONNode returns the kind, the span and the fields of the JSON of a
+// node, an empty kind for null.
+func decodeJSONNode(data json.RawMessage) (string, Span, map[string]json.RawMessage, error) {
+	var span Span
+	if isJSONNull(data) {
+		return "", span, nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", span, nil, err
+	}
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil || kind == "" {
+		return "", span, nil, fmt.Errorf("node without kind")
+	}
+	if !isJSONNull(fields["span"]) {
+		if err := json.Unmarshal(fields["span"], &span); err != nil {
+			return "", span, nil, fmt.Errorf("%s.span: %s", kind, err)
+		}
+	}
+	return kind, span, fields, nil
+}
+
+func decodeValue(data json.RawMessage) (interface{}, error) {
+	if isJSONNull(data) {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	switch value.(type) {
+	case bool, float64, string:
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid literal %s", data)
+}
+
+func decodeToken(data json.RawMessage) (Token, error) {
+	if isJSONNull(data) {
+		return Token{}, nil
+	}
+	var t jsonToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Token{}, err
+	}
+	typ, ok := tokenTypes[t.Type]
+	if !ok {
+		return Token{}, fmt.Errorf("unknown token type %q", t.Type)
+	}
+	token := Token{typ: typ, lexeme: t.Lexeme, lexval: t.Value, row: t.Row, col: t.Col, offset: t.Offset}
+	if typ == NUMBER {
+		if _, ok := t.Value.(float64); !ok {
+			return Token{}, fmt.Errorf("number token without value")
+		}
+	}
+	return token, nil
+}
+
+func decodeTokenPtr(data json.RawMessage) (*Token, error) {
+	if isJSONNull(data) {
+		return nil, nil
+	}
+	token, err := decodeToken(data)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// decodeList decodes the elements of a JSON array with decode.
+func decodeList(data json.RawMessage, decode func(data json.RawMessage) error) error {
+	if isJSONNull(data) {
+		return nil
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return err
+	}
+	for n, elem := range elems {
+		if err := decode(elem); err != nil {
+			return fmt.Errorf("[%d]: %s", n, err)
+		}
+	}
+	return nil
+}
+
+func decodeTokenList(data json.RawMessage) ([]Token, error) {
+	tokens := []Token{}
+	err := decodeList(data, func(data json.RawMessage) error {
+		token, err := decodeToken(data)
+		tokens = append(tokens, token)
+		return err
+	})
+	return tokens, err
+}
+
+func decodeExprList(data json.RawMessage) ([]Expr, error) {
+	exprs := []Expr{}
+	err := decodeList(data, func(data json.RawMessage) error {
+		expr, err := decodeExpr(data)
+		exprs = append(exprs, expr)
+		return err
+	})
+	return exprs, err
+}
+
+func decodeStmtList(data json.RawMessage) ([]Stmt, error) {
+	stmts := []Stmt{}
+	err := decodeList(data, func(data json.RawMessage) error {
+		stmt, err := decodeStmt(data)
+		if err == nil && stmt == nil {
+			err = fmt.Errorf("null statement")
+		}
+		stmts = append(stmts, stmt)
+		return err
+	})
+	return stmts, err
+}
+
+func decodeVariable(data json.RawMessage) (*ExprVariable, error) {
+	expr, err := decodeExpr(data)
+	if err != nil || expr == nil {
+		return nil, err
+	}
+	variable, ok := expr.(*ExprVariable)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a variable", expr)
+	}
+	return variable, nil
+}
+
+func decodeVariableList(data json.RawMessage) ([]*ExprVariable, error) {
+	variables := []*ExprVariable{}
+	err := decodeList(data, func(data json.RawMessage) error {
+		variable, err := decodeVariable(data)
+		if err == nil && variable == nil {
+			err = fmt.Errorf("null variable")
+		}
+		variables = append(variables, variable)
+		return err
+	})
+	return variables, err
+}
+
+func decodeFunList(data json.RawMessage) ([]*StmtFun, error) {
+	funs := []*StmtFun{}
+	err := decodeList(data, func(data json.RawMessage) error {
+		stmt, err := decodeStmt(data)
+		if err != nil {
+			return err
+		}
+		fun, ok := stmt.(*StmtFun)
+		if !ok {
+			return fmt.Errorf("%T is not a function", stmt)
+		}
+		funs = append(funs, fun)
+		return nil
+	})
+	return funs, err
+}
+
+// LoadAST returns the statements of the JSON written by golox ast -json.
+func LoadAST(data []byte) ([]Stmt, error) {
+	statements, err := decodeStmtList(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syntax tree: %s", err)
+	}
+	return statements, nil
+}
+
+// readSource returns the content of the only file of args, or of the
+// standard input if there is none.
+func readSource(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		data, err := io.ReadAll(os.Stdin)
+		return string(data), err
+	case 1:
+		data, err := os.ReadFile(args[0])
+		return string(data), err
+	}
+	return "", fmt.Errorf("too many files")
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// tokensCommand prints the tokens of a script.
+func tokensCommand(args []string) error {
+	flags := flag.NewFlagSet("tokens", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tokens as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s tokens [-json] [file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	src, err := readSource(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	logger.Reset(src, os.Stdout, os.Stderr)
+	tokens, err := NewScanner(src).Tokens()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, tokens)
+	}
+	for _, token := range tokens {
+		fmt.Printf("%4d:%-3d %s\n", token.row, token.col, token)
+	}
+	return nil
+}
+
+// astCommand prints the syntax tree of a script, or of the JSON of a tree
+// with -load.
+func astCommand(args []string) error {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	load := flags.Bool("load", false, "read the tree from JSON written with -json instead of source")
+	runTree := flags.Bool("run", false, "run the tree instead of printing it")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s ast [-json] [-load] [-run] [file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	src, err := readSource(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	var statements []Stmt
+	if *load {
+		logger.Reset("", os.Stdout, os.Stderr)
+		if statements, err = LoadAST([]byte(src)); err != nil {
+			return err
+		}
+	} else {
+		logger.Reset(src, os.Stdout, os.Stderr)
+		tokens, err := NewScanner(src).Tokens()
+		if err != nil {
+			return err
+		}
+		if statements, err = NewParser(tokens).Parse(); err != nil {
+			return err
+		}
+	}
+
+	if *runTree {
+		if err := configure(interpreter); err != nil {
+			return err
+		}
+		resolution, err := resolver.Resolve(statements)
+		if err != nil {
+			return err
+		}
+		interpreter.SetResolution(resolution)
+		return interpreter.Interprete(statements)
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, statements)
+	}
+	return NewAstPrinter().Fprint(os.Stdout, statements)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// BenchResult summarizes the runs of one benchmark script.
+type BenchResult struct {
+	Name   string        `json:"name"`
+	Runs   int           `json:"runs"`
+	Min    time.Duration `json:"min_ns"`
+	Median time.Duration `json:"median_ns"`
+	Stddev time.Duration `json:"stddev_ns"`
+	Allocs uint64        `json:"allocs_per_run"`
+	Bytes  uint64        `json:"bytes_per_run"`
+}
+
+// BenchReport is the format of saved baselines.
+type BenchReport struct {
+	Results []BenchResult `json:"results"`
+}
+
+func (r *BenchReport) find(name string) *BenchResult {
+	for n := range r.Results {
+		if r.Results[n].Name == name {
+			return &r.Results[n]
+		}
+	}
+	return nil
+}
+
+// benchCommand runs benchmark scripts several times and reports their
+// running time and allocations.
+func benchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	runs := flags.Int("n", 5, "number of runs of each script")
+	baseline := flags.String("baseline", "", "compare with the results saved in `file`")
+	save := flags.String("save", "", "save the results as a baseline to `file`")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s bench [flags] files...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || *runs < 1 {
+		flags.Usage()
+		return fmt.Errorf("bench: no benchmark to run")
+	}
+
+	var base *BenchReport
+	if *baseline != "" {
+		var err error
+		if base, err = loadBenchReport(*baseline); err != nil {
+			return err
+		}
+	}
+
+	report := &BenchReport{}
+	for _, filename := range flags.Args() {
+		result, err := benchFile(filename, *runs)
+		if err != nil {
+			return err
+		}
+		report.Results = append(report.Results, *result)
+	}
+
+	printBenchReport(os.Stdout, report, base)
+
+	if *save != "" {
+		return saveBenchReport(*save, report)
+	}
+	return nil
+}
+
+// benchFile runs the script filename runs times, each time in a new
+// interpreter whose output is discarded.
+func benchFile(filename string, runs int) (*BenchResult, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	src := string(data)
+
+	times := make([]time.Duration, runs)
+	var allocs, bytes uint64
+	for n := 0; n < runs; n++ {
+		interpreter := NewInterpreter()
+		if err := configure(interpreter); err != nil {
+			return nil, err
+		}
+		interpreter.SetOutput(io.Discard)
+		resolver := NewResolver()
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		err := runSource(src, interpreter, resolver)
+		times[n] = time.Since(start)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		allocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	result := &BenchResult{
+		Name:   filepath.Base(filename),
+		Runs:   runs,
+		Allocs: allocs / uint64(runs),
+		Bytes:  bytes / uint64(runs),
+	}
+	result.Min, result.Median, result.Stddev = durationStats(times)
+	return result, nil
+}
+
+func durationStats(times []time.Duration) (min, median, stddev time.Duration) {
+	sorted := append([]time.Duration(nil), times...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+
+	min = sorted[0]
+	if n := len(sorted); n%2 == 1 {
+		median = sorted[n/2]
+	} else {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	var mean float64
+	for _, t := range times {
+		mean += float64(t)
+	}
+	mean /= float64(len(times))
+	var variance float64
+	for _, t := range times {
+		variance += (float64(t) - mean) * (float64(t) - mean)
+	}
+	variance /= float64(len(times))
+	stddev = time.Duration(math.Sqrt(variance))
+	return min, median, stddev
+}
+
+// printBenchReport prints the results, with the change of the median time
+// relative to base if given.
+func printBenchReport(w io.Writer, report, base *BenchReport) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	header := "benchmark\truns\tmin\tmedian\tstddev\tallocs/run\tbytes/run\t"
+	if base != nil {
+		header += "vs baseline\t"
+	}
+	fmt.Fprintln(tw, header)
+	for _, r := range report.Results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%d\t%d\t", r.Name, r.Runs,
+			roundDuration(r.Min), roundDuration(r.Median), roundDuration(r.Stddev), r.Allocs, r.Bytes)
+		if base != nil {
+			if old := base.find(r.Name); old != nil && old.Median > 0 {
+				delta := float64(r.Median-old.Median) / float64(old.Median) * 100
+				fmt.Fprintf(tw, "%+.1f%%\t", delta)
+			} else {
+				fmt.Fprint(tw, "-\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond / 10)
+}
+
+func loadBenchReport(filename string) (*BenchReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	report := &BenchReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return report, nil
+}
+
+func saveBenchReport(filename string, report *BenchReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
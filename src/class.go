@@ -2,54 +2,129 @@ package main
 
 import (
 	"fmt"
+	"sort"
 )
 
 type LoxClass struct {
-	superclass *LoxClass
-	name       string
-	methods    map[string]*LoxFunction
+	superclass    *LoxClass
+	name          string
+	methods       map[string]*LoxFunction
+	staticMethods map[string]*LoxFunction
+	getters       map[string]*LoxFunction
+	setters       map[string]*LoxFunction
+
+	flat *methodTable // memoized by table
 }
 
 var _ LoxCallable = &LoxClass{}
 
 func NewLoxClass(def *StmtClass, super *LoxClass) *LoxClass {
 	return &LoxClass{
-		superclass: super,
-		name:       def.Name,
-		methods:    make(map[string]*LoxFunction),
+		superclass:    super,
+		name:          def.Name.lexeme,
+		methods:       make(map[string]*LoxFunction),
+		staticMethods: make(map[string]*LoxFunction),
+		getters:       make(map[string]*LoxFunction),
+		setters:       make(map[string]*LoxFunction),
 	}
 }
 
-func (class *LoxClass) Arity() int {
+func (class *LoxClass) Arity() (min, max int) {
 	init := class.FindMethod("init")
 	if init == nil {
-		return 0
+		return 0, 0
 	}
 	return init.Arity()
 }
 
 func (class *LoxClass) Call(i *Interpreter, args []interface{}) (interface{}, error) {
+	if err := i.alloc(MemInstances, instanceSize); err != nil {
+		return nil, err
+	}
 	instance := NewLoxInstance(class)
 	init := class.FindMethod("init")
 	if init == nil {
 		return instance, nil
 	}
-	bind(init, instance)
-	return init.Call(i, args)
+	return init.invoke(i, instance, args)
 }
 
 func (class *LoxClass) DefineMethod(name string, fn *LoxFunction) {
 	class.methods[name] = fn
+	class.flat = nil
 }
 
 func (class *LoxClass) FindMethod(name string) *LoxFunction {
-	if fn, ok := class.methods[name]; ok {
-		return fn
+	return class.table().methods[name]
+}
+
+func (class *LoxClass) DefineStaticMethod(name string, fn *LoxFunction) {
+	class.staticMethods[name] = fn
+	class.flat = nil
+}
+
+func (class *LoxClass) FindStaticMethod(name string) *LoxFunction {
+	return class.table().staticMethods[name]
+}
+
+func (class *LoxClass) DefineGetter(name string, fn *LoxFunction) {
+	class.getters[name] = fn
+	class.flat = nil
+}
+
+func (class *LoxClass) FindGetter(name string) *LoxFunction {
+	return class.table().getters[name]
+}
+
+func (class *LoxClass) DefineSetter(name string, fn *LoxFunction) {
+	class.setters[name] = fn
+	class.flat = nil
+}
+
+func (class *LoxClass) FindSetter(name string) *LoxFunction {
+	return class.table().setters[name]
+}
+
+// methodTable holds the methods of a class and of all its superclasses, so
+// that a lookup does not walk the superclass chain.
+type methodTable struct {
+	methods       map[string]*LoxFunction
+	staticMethods map[string]*LoxFunction
+	getters       map[string]*LoxFunction
+	setters       map[string]*LoxFunction
+}
+
+// table returns the flattened method table of the class, built on first use
+// after the class changed.
+func (class *LoxClass) table() *methodTable {
+	if class.flat != nil {
+		return class.flat
+	}
+	flat := &methodTable{
+		methods:       make(map[string]*LoxFunction),
+		staticMethods: make(map[string]*LoxFunction),
+		getters:       make(map[string]*LoxFunction),
+		setters:       make(map[string]*LoxFunction),
 	}
 	if class.superclass != nil {
-		return class.superclass.FindMethod(name)
+		super := class.superclass.table()
+		copyMethods(flat.methods, super.methods)
+		copyMethods(flat.staticMethods, super.staticMethods)
+		copyMethods(flat.getters, super.getters)
+		copyMethods(flat.setters, super.setters)
+	}
+	copyMethods(flat.methods, class.methods)
+	copyMethods(flat.staticMethods, class.staticMethods)
+	copyMethods(flat.getters, class.getters)
+	copyMethods(flat.setters, class.setters)
+	class.flat = flat
+	return flat
+}
+
+func copyMethods(dst, src map[string]*LoxFunction) {
+	for name, fn := range src {
+		dst[name] = fn
 	}
-	return nil
 }
 
 func (class *LoxClass) String() string {
@@ -71,3 +146,35 @@ func NewLoxInstance(class *LoxClass) *LoxInstance {
 func (i *LoxInstance) String() string {
 	return fmt.Sprintf("%s instance", i.class.name)
 }
+
+// LoxTrait is a named set of methods which classes copy into their own method
+// table through the with clause.
+type LoxTrait struct {
+	name    string
+	methods map[string]*LoxFunction
+}
+
+func NewLoxTrait(def *StmtTrait) *LoxTrait {
+	return &LoxTrait{
+		name:    def.Name.lexeme,
+		methods: make(map[string]*LoxFunction),
+	}
+}
+
+func (trait *LoxTrait) DefineMethod(name string, fn *LoxFunction) {
+	trait.methods[name] = fn
+}
+
+// MethodNames returns the sorted names of the trait methods.
+func (trait *LoxTrait) MethodNames() []string {
+	names := make([]string, 0, len(trait.methods))
+	for name := range trait.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (trait *LoxTrait) String() string {
+	return trait.name
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CoverageProfile is the coverage of scripts, as saved by the -coverage mode.
+type CoverageProfile struct {
+	Files []*FileCoverage `json:"files"`
+}
+
+// FileCoverage is the coverage of a script: the number of runs of the
+// statements starting on each line and the outcomes of its branches.
+type FileCoverage struct {
+	Path     string           `json:"path"`
+	Lines    []LineCoverage   `json:"lines"`
+	Branches []BranchCoverage `json:"branches"`
+}
+
+type LineCoverage struct {
+	Line int `json:"line"`
+	Hits int `json:"hits"`
+}
+
+// BranchCoverage counts the outcomes of a condition: an if, a loop, or the
+// left operand of and or or, which is taken when the right one runs.
+type BranchCoverage struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Kind     string `json:"kind"`
+	Taken    int    `json:"taken"`
+	NotTaken int    `json:"notTaken"`
+}
+
+// Coverage is the hooks of the -coverage mode, which count the runs of the
+// statements and the outcomes of the branches of a script.
+type Coverage struct {
+	path       string
+	statements []Stmt
+	branches   []interface{}
+	hits       map[Stmt]int
+	outcomes   map[interface{}]*[2]int // taken and not taken
+}
+
+// NewCoverage returns the coverage of the statements of the script path,
+// which are to be run with the coverage as hooks.
+func NewCoverage(path string, statements []Stmt) *Coverage {
+	c := &Coverage{
+		path:     path,
+		hits:     make(map[Stmt]int),
+		outcomes: make(map[interface{}]*[2]int),
+	}
+	for _, statement := range statements {
+		walkTree(statement, func(node interface{}) {
+			switch node.(type) {
+			case *StmtBlock:
+			case Stmt:
+				c.statements = append(c.statements, node.(Stmt))
+			}
+			switch node.(type) {
+			case *StmtIf, *StmtWhile, *ExprLogical:
+				c.branches = append(c.branches, node)
+				c.outcomes[node] = &[2]int{}
+			}
+		})
+	}
+	return c
+}
+
+var _ BranchHooks = &Coverage{}
+
+func (c *Coverage) Statement(stmt Stmt) error {
+	c.hits[stmt]++
+	return nil
+}
+
+func (c *Coverage) StatementDone(stmt Stmt) {}
+
+func (c *Coverage) Call(fn *LoxFunction, args []interface{}) {}
+
+func (c *Coverage) Return(fn *LoxFunction, value interface{}, err error) {}
+
+func (c *Coverage) Assign(name Token, value interface{}) {}
+
+func (c *Coverage) Error(err error) {}
+
+func (c *Coverage) Branch(node interface{}, taken bool) {
+	if outcomes, ok := c.outcomes[node]; ok {
+		if taken {
+			outcomes[0]++
+		} else {
+			outcomes[1]++
+		}
+	}
+}
+
+// Profile returns the coverage recorded so far. The hits of a line are the
+// most runs of the statements starting on it.
+func (c *Coverage) Profile() *FileCoverage {
+	lines := make(map[int]int)
+	for _, stmt := range c.statements {
+		span := stmt.Span()
+		if span.IsZero() {
+			continue
+		}
+		row := span.Start.Row
+		if hits, seen := lines[row]; !seen || c.hits[stmt] > hits {
+			lines[row] = c.hits[stmt]
+		}
+	}
+
+	fc := &FileCoverage{Path: c.path, Lines: make([]LineCoverage, 0), Branches: make([]BranchCoverage, 0)}
+	for line, hits := range lines {
+		fc.Lines = append(fc.Lines, LineCoverage{Line: line, Hits: hits})
+	}
+	sort.Slice(fc.Lines, func(a, b int) bool { return fc.Lines[a].Line < fc.Lines[b].Line })
+
+	for _, node := range c.branches {
+		var pos Position
+		var kind string
+		switch n := node.(type) {
+		case *StmtIf:
+			pos, kind = n.Cond.Span().Start, "if"
+		case *StmtWhile:
+			pos, kind = n.Keyword.start(), n.Keyword.lexeme
+		case *ExprLogical:
+			pos, kind = n.Operator.start(), n.Operator.lexeme
+		}
+		outcomes := c.outcomes[node]
+		fc.Branches = append(fc.Branches, BranchCoverage{
+			Line:     pos.Row,
+			Column:   pos.Col,
+			Kind:     kind,
+			Taken:    outcomes[0],
+			NotTaken: outcomes[1],
+		})
+	}
+	sort.SliceStable(fc.Branches, func(a, b int) bool {
+		x, y := fc.Branches[a], fc.Branches[b]
+		return x.Line < y.Line || x.Line == y.Line && x.Column < y.Column
+	})
+	return fc
+}
+
+// walkTree calls visit on node and on the nodes it contains, in source
+// order.
+func walkTree(node interface{}, visit func(node interface{})) {
+	if node == nil {
+		return
+	}
+	switch n := node.(type) {
+	case Expr:
+		if n == nil {
+			return
+		}
+	case Stmt:
+		if n == nil {
+			return
+		}
+	}
+	visit(node)
+
+	walk := func(nodes ...interface{}) {
+		for _, node := range nodes {
+			walkTree(node, visit)
+		}
+	}
+	switch n := node.(type) {
+	case *ExprAssign:
+		walk(n.Value)
+	case *ExprUnary:
+		walk(n.Expression)
+	case *ExprGrouping:
+		walk(n.Expression)
+	case *ExprBinary:
+		walk(n.Left, n.Right)
+	case *ExprLogical:
+		walk(n.Left, n.Right)
+	case *ExprCall:
+		walk(n.Callee)
+		for _, arg := range n.Args {
+			walk(arg)
+		}
+	case *ExprGet:
+		walk(n.Object)
+	case *ExprSet:
+		walk(n.Object, n.Value)
+	case *ExprIndex:
+		walk(n.Object, n.Index)
+	case *ExprSpread:
+		walk(n.Expression)
+	case *StmtExpression:
+		walk(n.Expression)
+	case *StmtPrint:
+		walk(n.Expression)
+	case *StmtVar:
+		if n.Initializer != nil {
+			walk(n.Initializer)
+		}
+	case *StmtBlock:
+		for _, stmt := range n.Statements {
+			walk(stmt)
+		}
+	case *StmtIf:
+		walk(n.Cond, n.Then)
+		if n.Else != nil {
+			walk(n.Else)
+		}
+	case *StmtWhile:
+		walk(n.Cond, n.Body)
+	case *StmtFun:
+		for _, value := range n.Defaults {
+			if value != nil {
+				walk(value)
+			}
+		}
+		for _, stmt := range n.Body {
+			walk(stmt)
+		}
+	case *StmtReturn:
+		if n.Value != nil {
+			walk(n.Value)
+		}
+	case *StmtClass:
+		for _, methods := range [][]*StmtFun{n.Methods, n.StaticMethods, n.Getters, n.Setters} {
+			for _, method := range methods {
+				walk(method)
+			}
+		}
+	case *StmtTrait:
+		for _, method := range n.Methods {
+			walk(method)
+		}
+	}
+}
+
+// runCoverage runs the script filename and saves its coverage to the file
+// given by the -coverage flag, even if the script fails.
+func runCoverage(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	statements, resolution, err := compile(string(data), resolver)
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	coverage := NewCoverage(path, statements)
+	interpreter.SetHooks(coverage)
+	interpreter.SetResolution(resolution)
+	runErr := interpreter.Interprete(statements)
+
+	profile := &CoverageProfile{Files: []*FileCoverage{coverage.Profile()}}
+	if err := saveCoverage(*coverageOut, profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return runErr
+}
+
+func saveCoverage(filename string, profile *CoverageProfile) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func loadCoverage(filename string) (*CoverageProfile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	profile := &CoverageProfile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return profile, nil
+}
+
+// merge adds the coverage of other to the profile, summing the counts of
+// the same scripts.
+func (p *CoverageProfile) merge(other *CoverageProfile) {
+	for _, file := range other.Files {
+		var same *FileCoverage
+		for _, f := range p.Files {
+			if f.Path == file.Path {
+				same = f
+				break
+			}
+		}
+		if same == nil {
+			p.Files = append(p.Files, file)
+			continue
+		}
+		hits := make(map[int]int)
+		for _, line := range file.Lines {
+			hits[line.Line] = line.Hits
+		}
+		for n := range same.Lines {
+			same.Lines[n].Hits += hits[same.Lines[n].Line]
+		}
+		for n := range same.Branches {
+			if n < len(file.Branches) {
+				same.Branches[n].Taken += file.Branches[n].Taken
+				same.Branches[n].NotTaken += file.Branches[n].NotTaken
+			}
+		}
+	}
+}
+
+// coverCommand reports the coverage saved by the -coverage mode.
+func coverCommand(args []string) error {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	format := flags.String("format", "text", "report `format`: text, html or lcov")
+	out := flags.String("o", "", "write the report to `file` instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s cover [-format text|html|lcov] [-o file] profiles...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("cover: no coverage profile")
+	}
+
+	profile := &CoverageProfile{}
+	for _, filename := range flags.Args() {
+		p, err := loadCoverage(filename)
+		if err != nil {
+			return err
+		}
+		profile.merge(p)
+	}
+	sort.Slice(profile.Files, func(a, b int) bool { return profile.Files[a].Path < profile.Files[b].Path })
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "text":
+		return writeCoverageText(w, profile)
+	case "html":
+		return writeCoverageHTML(w, profile)
+	case "lcov":
+		return writeLCOV(w, profile)
+	}
+	return fmt.Errorf("cover: unknown format %q", *format)
+}
+
+// coverageSummary counts the covered lines and branch outcomes of a script.
+type coverageSummary struct {
+	Lines, LinesHit       int
+	Branches, BranchesHit int
+}
+
+func (fc *FileCoverage) summary() coverageSummary {
+	var s coverageSummary
+	for _, line := range fc.Lines {
+		s.Lines++
+		if line.Hits > 0 {
+			s.LinesHit++
+		}
+	}
+	for _, branch := range fc.Branches {
+		s.Branches += 2
+		if branch.Taken > 0 {
+			s.BranchesHit++
+		}
+		if branch.NotTaken > 0 {
+			s.BranchesHit++
+		}
+	}
+	return s
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func (s coverageSummary) String() string {
+	return fmt.Sprintf("%.1f%% of lines (%d/%d), %.1f%% of branches (%d/%d)",
+		percent(s.LinesHit, s.Lines), s.LinesHit, s.Lines,
+		percent(s.BranchesHit, s.Branches), s.BranchesHit, s.Branches)
+}
+
+// annotatedLine is a line of a script with its coverage.
+type annotatedLine struct {
+	Number   int
+	Text     string
+	Hits     int    // -1 if no statement starts on the line
+	Branches string // outcomes of the branches of the line
+	Class    string // hit, miss or partial, if the line has statements
+}
+
+// annotate returns the lines of the source of fc with their coverage.
+func (fc *FileCoverage) annotate() ([]annotatedLine, error) {
+	data, err := os.ReadFile(fc.Path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	annotated := make([]annotatedLine, len(lines))
+	for n, text := range lines {
+		annotated[n] = annotatedLine{Number: n + 1, Text: text, Hits: -1}
+	}
+	for _, line := range fc.Lines {
+		if line.Line < 1 || line.Line > len(annotated) {
+			continue
+		}
+		a := &annotated[line.Line-1]
+		a.Hits, a.Class = line.Hits, "hit"
+		if line.Hits == 0 {
+			a.Class = "miss"
+		}
+	}
+	for _, branch := range fc.Branches {
+		if branch.Line < 1 || branch.Line > len(annotated) {
+			continue
+		}
+		a := &annotated[branch.Line-1]
+		if a.Branches != "" {
+			a.Branches += ", "
+		}
+		a.Branches += fmt.Sprintf("%s taken %d, not taken %d", branch.Kind, branch.Taken, branch.NotTaken)
+		if a.Class == "hit" && (branch.Taken == 0 || branch.NotTaken == 0) {
+			a.Class = "partial"
+		}
+	}
+	return annotated, nil
+}
+
+// displayPath returns path relative to the working directory if it is in
+// it, as reports show it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// writeCoverageText writes the annotated source of the scripts, with the
+// hits of each line, ##### for lines never run.
+func writeCoverageText(w io.Writer, profile *CoverageProfile) error {
+	for _, fc := range profile.Files {
+		lines, err := fc.annotate()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: %s\n", displayPath(fc.Path), fc.summary())
+		for _, line := range lines {
+			hits := ""
+			switch {
+			case line.Hits == 0:
+				hits = "#####"
+			case line.Hits > 0:
+				hits = fmt.Sprint(line.Hits)
+			}
+			text := line.Text
+			if line.Branches != "" {
+				text += "  [" + line.Branches + "]"
+			}
+			fmt.Fprintf(w, "%7s %4d | %s\n", hits, line.Number, text)
+		}
+	}
+	return nil
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lox coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.line { display: block; }
+.number, .hits { display: inline-block; text-align: right; color: #888; padding-right: 1em; }
+.number { width: 4em; }
+.hits { width: 5em; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.partial { background: #ffd; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.Path}}</h2>
+<p>{{.Summary}}</p>
+<pre>{{range .Lines}}<span class="line {{.Class}}"{{if .Branches}} title="{{.Branches}}"{{end}}><span class="number">{{.Number}}</span><span class="hits">{{if ge .Hits 0}}{{.Hits}}{{end}}</span>{{.Text}}</span>{{end}}</pre>
+{{end}}</body>
+</html>
+`))
+
+// writeCoverageHTML writes a page of the annotated sources of the scripts,
+// lines partially run are the ones with a branch never or always taken.
+func writeCoverageHTML(w io.Writer, profile *CoverageProfile) error {
+	type file struct {
+		Path    string
+		Summary string
+		Lines   []annotatedLine
+	}
+	files := make([]file, 0, len(profile.Files))
+	for _, fc := range profile.Files {
+		lines, err := fc.annotate()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{Path: displayPath(fc.Path), Summary: fc.summary().String(), Lines: lines})
+	}
+	return coverageTemplate.Execute(w, files)
+}
+
+// writeLCOV writes the profile in the LCOV tracefile format.
+func writeLCOV(w io.Writer, profile *CoverageProfile) error {
+	for _, fc := range profile.Files {
+		fmt.Fprintln(w, "TN:")
+		fmt.Fprintf(w, "SF:%s\n", fc.Path)
+		for n, branch := range fc.Branches {
+			taken, notTaken := "-", "-"
+			if branch.Taken+branch.NotTaken > 0 {
+				taken, notTaken = fmt.Sprint(branch.Taken), fmt.Sprint(branch.NotTaken)
+			}
+			fmt.Fprintf(w, "BRDA:%d,%d,0,%s\n", branch.Line, n, taken)
+			fmt.Fprintf(w, "BRDA:%d,%d,1,%s\n", branch.Line, n, notTaken)
+		}
+		s := fc.summary()
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", s.Branches, s.BranchesHit)
+		for _, line := range fc.Lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line.Line, line.Hits)
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", s.Lines, s.LinesHit)
+		if _, err := fmt.Fprintln(w, "end_of_record"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
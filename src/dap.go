@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// dapCommand serves the Debug Adapter Protocol over stdin and stdout.
+func dapCommand(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s dap\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	return NewDAPServer(os.Stdin, os.Stdout).Serve()
+}
+
+// DAPServer is a debug adapter launching one Lox script under the debugger.
+// Requests are read by Serve while the script runs on its own goroutine.
+// The requests about the state of the script are queued and answered once
+// it is paused, by the goroutine of the script itself, so that Serve keeps
+// reading requests such as disconnect while the script runs.
+type DAPServer struct {
+	in  *bufio.Reader
+	mu  sync.Mutex // guards out and seq
+	out io.Writer
+	seq int
+
+	// 1 if lines and columns of the client start at 1, else 0
+	lineBase, colBase int
+
+	debugger    *Debugger
+	path        string // of the script, as given by the client
+	program     string // absolute path of the script
+	statements  []Stmt
+	stopOnEntry bool
+	cancel      context.CancelFunc
+	started     bool
+	done        chan struct{} // closed when the script ended
+
+	qmu      sync.Mutex    // guards pending and ended
+	pending  []dapPending  // answered by the paused script, in order
+	ended    bool          // set when the script ended
+	queued   chan struct{} // signalled when a request is pending
+	quit     chan struct{} // closed to stop the script
+	stopOnce sync.Once
+
+	// containers of the variables of the current pause, referenced from 1
+	refs []func() []debugVariable
+}
+
+func NewDAPServer(in io.Reader, out io.Writer) *DAPServer {
+	return &DAPServer{
+		in:       bufio.NewReader(in),
+		out:      out,
+		lineBase: 1,
+		colBase:  1,
+		done:     make(chan struct{}),
+		queued:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+}
+
+var _ DebugFrontend = &DAPServer{}
+
+// DAP messages, only with the fields used
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapBreakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Source dapSource `json:"source"`
+	Line   int       `json:"line"`
+	Column int       `json:"column"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// Serve handles requests until the disconnect request or the end of input.
+func (s *DAPServer) Serve() error {
+	defer s.stop()
+	for {
+		data, err := readFrame(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req dapRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			// without its seq, the request can't be answered
+			continue
+		}
+		if err := s.handle(&req); err != nil {
+			if err == errExit {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *DAPServer) send(msg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	return writeFrame(s.out, msg)
+}
+
+func (s *DAPServer) respond(req *dapRequest, body interface{}) error {
+	return s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *DAPServer) fail(req *dapRequest, msg string) error {
+	return s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: msg})
+}
+
+func (s *DAPServer) event(name string, body interface{}) error {
+	return s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// output sends text written by the script as an output event.
+func (s *DAPServer) output(category, text string) error {
+	return s.event("output", map[string]string{"category": category, "output": text})
+}
+
+// dapOutput is the output of print statements.
+type dapOutput struct {
+	s *DAPServer
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	if err := o.s.output("stdout", string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *DAPServer) handle(req *dapRequest) error {
+	switch req.Command {
+	case "initialize":
+		var args struct {
+			LinesStartAt1   *bool `json:"linesStartAt1"`
+			ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+			s.colBase = 0
+		}
+		return s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		})
+
+	case "launch":
+		return s.launch(req)
+
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+
+	case "setExceptionBreakpoints":
+		return s.respond(req, map[string]interface{}{"breakpoints": []dapBreakpoint{}})
+
+	case "configurationDone":
+		if s.debugger == nil {
+			return s.fail(req, "no script launched")
+		}
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		if !s.started {
+			s.started = true
+			go s.run()
+		}
+		return nil
+
+	case "threads":
+		return s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": 1, "name": "main"}},
+		})
+
+	case "stackTrace", "scopes", "variables", "evaluate":
+		return s.whilePaused(req, func() (bool, error) {
+			body, err := s.inspect(req)
+			if err != nil {
+				return false, s.fail(req, err.Error())
+			}
+			return false, s.respond(req, body)
+		})
+
+	case "continue", "next", "stepIn", "stepOut":
+		return s.whilePaused(req, func() (bool, error) {
+			switch req.Command {
+			case "continue":
+				s.debugger.Continue()
+			case "next":
+				s.debugger.StepOver()
+			case "stepIn":
+				s.debugger.StepIn()
+			case "stepOut":
+				s.debugger.StepOut()
+			}
+			if req.Command == "continue" {
+				return true, s.respond(req, map[string]bool{"allThreadsContinued": true})
+			}
+			return true, s.respond(req, nil)
+		})
+
+	case "terminate":
+		s.stop()
+		return s.respond(req, nil)
+
+	case "disconnect":
+		s.stop()
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return errExit
+	}
+	return s.fail(req, "unsupported request "+req.Command)
+}
+
+// launch loads the script, which starts once the client is done with the
+// configuration.
+func (s *DAPServer) launch(req *dapRequest) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.fail(req, err.Error())
+	}
+	if s.debugger != nil {
+		return s.fail(req, "a script is already launched")
+	}
+
+	data, err := os.ReadFile(args.Program)
+	if err != nil {
+		return s.fail(req, err.Error())
+	}
+	src := string(data)
+	logger.Reset(src, io.Discard, io.Discard)
+
+	tokens, err := NewScanner(src).Tokens()
+	if err != nil {
+		return s.fail(req, err.Error())
+	}
+	statements, err := NewParser(tokens).Parse()
+	if err != nil {
+		return s.fail(req, err.Error())
+	}
+	resolver := NewResolver()
+	resolver.RecordScopes()
+	resolution, err := resolver.Resolve(statements)
+	if err != nil {
+		return s.fail(req, err.Error())
+	}
+
+	interpreter := NewInterpreter()
+	if err := noObservers("dap"); err != nil {
+		return s.fail(req, err.Error())
+	}
+	if err := configure(interpreter); err != nil {
+		return s.fail(req, err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	interpreter.SetContext(ctx)
+	interpreter.SetOutput(dapOutput{s})
+	interpreter.SetResolution(resolution)
+
+	s.debugger = NewDebugger(interpreter, resolution)
+	s.debugger.SetFrontend(s)
+	s.path = args.Program
+	s.program, _ = filepath.Abs(args.Program)
+	s.statements = statements
+	s.stopOnEntry = args.StopOnEntry
+	s.cancel = cancel
+
+	if err := s.respond(req, nil); err != nil {
+		return err
+	}
+	return s.event("initialized", nil)
+}
+
+// run runs the script, on its own goroutine.
+func (s *DAPServer) run() {
+	defer close(s.done)
+	code := 0
+	if err := s.debugger.Run(s.statements, s.stopOnEntry); err != nil {
+		select {
+		case <-s.quit:
+			// cancelled by the client
+		default:
+			s.output("stderr", err.Error()+"\n")
+			code = 1
+		}
+	}
+	s.event("exited", map[string]int{"exitCode": code})
+	s.event("terminated", nil)
+
+	s.qmu.Lock()
+	s.ended = true
+	pending := s.pending
+	s.pending = nil
+	s.qmu.Unlock()
+	for _, p := range pending {
+		s.fail(p.req, "the script is not paused")
+	}
+}
+
+// stop stops the script, if it runs, and waits for its end.
+func (s *DAPServer) stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		if s.cancel != nil {
+			s.cancel()
+		}
+	})
+	if s.started {
+		<-s.done
+	}
+}
+
+func (s *DAPServer) setBreakpoints(req *dapRequest) error {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.fail(req, err.Error())
+	}
+	if s.debugger == nil {
+		return s.fail(req, "no script launched")
+	}
+
+	path, _ := filepath.Abs(args.Source.Path)
+	if path == s.program {
+		s.debugger.ClearBreakpoints()
+	}
+	breakpoints := make([]dapBreakpoint, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		requested := bp.Line + 1 - s.lineBase
+		if path != s.program {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: bp.Line, Message: "not the launched script"})
+			continue
+		}
+		line, ok := s.debugger.SetBreakpoint(requested)
+		if !ok {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: bp.Line, Message: "no statement at this line"})
+			continue
+		}
+		breakpoints = append(breakpoints, dapBreakpoint{Verified: true, Line: line - 1 + s.lineBase})
+	}
+	return s.respond(req, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+// Paused tells the client the script stopped and answers the pending
+// requests until one resumes the script.
+func (s *DAPServer) Paused(reason string) error {
+	s.refs = nil
+
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+	for {
+		s.qmu.Lock()
+		var next *dapPending
+		if len(s.pending) > 0 {
+			next = &s.pending[0]
+			s.pending = s.pending[1:]
+		}
+		s.qmu.Unlock()
+		if next != nil {
+			if resume, _ := next.answer(); resume {
+				return nil
+			}
+			continue
+		}
+
+		select {
+		case <-s.queued:
+		case <-s.quit:
+			return errQuit
+		}
+	}
+}
+
+// dapPending is a request about the state of the script, answered while
+// it is paused.
+type dapPending struct {
+	req *dapRequest
+	// replies to req and tells if the script resumes
+	answer func() (resume bool, err error)
+}
+
+// whilePaused queues answer to be run at the next pause of the script, or
+// at once if it is paused. The request fails if the script is not started
+// or ends first.
+func (s *DAPServer) whilePaused(req *dapRequest, answer func() (resume bool, err error)) error {
+	if !s.started {
+		return s.fail(req, "the script is not paused")
+	}
+	s.qmu.Lock()
+	if s.ended {
+		s.qmu.Unlock()
+		return s.fail(req, "the script is not paused")
+	}
+	s.pending = append(s.pending, dapPending{req, answer})
+	s.qmu.Unlock()
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// inspect answers a request about the state of the paused script.
+func (s *DAPServer) inspect(req *dapRequest) (interface{}, error) {
+	var args struct {
+		StartFrame         int    `json:"startFrame"`
+		Levels             int    `json:"levels"`
+		FrameID            int    `json:"frameId"`
+		VariablesReference int    `json:"variablesReference"`
+		Expression         string `json:"expression"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	d := s.debugger
+	if args.FrameID < 0 || args.FrameID >= len(d.frames) {
+		return nil, fmt.Errorf("no frame %d", args.FrameID)
+	}
+
+	switch req.Command {
+	case "stackTrace":
+		frames := make([]dapStackFrame, 0, len(d.frames))
+		for n := args.StartFrame; n < len(d.frames); n++ {
+			if args.Levels > 0 && len(frames) == args.Levels {
+				break
+			}
+			frame := d.frame(n)
+			line, col := frame.line()
+			frames = append(frames, dapStackFrame{
+				ID:     n,
+				Name:   frame.name(),
+				Source: dapSource{Name: filepath.Base(s.program), Path: s.path},
+				Line:   line - 1 + s.lineBase,
+				Column: col - 1 + s.colBase,
+			})
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(d.frames)}, nil
+
+	case "scopes":
+		frame := args.FrameID
+		return map[string]interface{}{"scopes": []dapScope{
+			{Name: "Locals", VariablesReference: s.reference(func() []debugVariable { return d.locals(frame) })},
+			{Name: "Globals", VariablesReference: s.reference(d.globals)},
+		}}, nil
+
+	case "variables":
+		n := args.VariablesReference - 1
+		if n < 0 || n >= len(s.refs) {
+			return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+		}
+		vars := make([]dapVariable, 0)
+		for _, v := range s.refs[n]() {
+			vars = append(vars, s.variable(v))
+		}
+		return map[string]interface{}{"variables": vars}, nil
+
+	case "evaluate":
+		value, err := d.evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("%s", debugError(err))
+		}
+		v := s.variable(debugVariable{value: value})
+		return map[string]interface{}{
+			"result":             v.Value,
+			"type":               v.Type,
+			"variablesReference": v.VariablesReference,
+		}, nil
+	}
+	return nil, nil
+}
+
+// reference returns the reference of a container of variables, valid until
+// the script resumes.
+func (s *DAPServer) reference(children func() []debugVariable) int {
+	s.refs = append(s.refs, children)
+	return len(s.refs)
+}
+
+// variable describes a variable, instances and lists have their fields and
+// elements as children.
+func (s *DAPServer) variable(v debugVariable) dapVariable {
+	dv := dapVariable{Name: v.name, Value: debugString(v.value), Type: typeName(v.value)}
+	switch value := v.value.(type) {
+	case *LoxInstance:
+		dv.VariablesReference = s.reference(func() []debugVariable {
+			names := make([]string, 0, len(value.fileds))
+			for name := range value.fileds {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			vars := make([]debugVariable, len(names))
+			for n, name := range names {
+				vars[n] = debugVariable{name: name, value: value.fileds[name]}
+			}
+			return vars
+		})
+	case *LoxList:
+		dv.VariablesReference = s.reference(func() []debugVariable {
+			vars := make([]debugVariable, len(value.elements))
+			for n, element := range value.elements {
+				vars[n] = debugVariable{name: fmt.Sprintf("[%d]", n), value: element}
+			}
+			return vars
+		})
+	}
+	return dv
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errQuit stops a script run by the debugger.
+var errQuit = errors.New("debugger: quit")
+
+// stepMode tells where the debugger pauses next, besides breakpoints.
+type stepMode int
+
+const (
+	runMode  stepMode = iota // only at breakpoints
+	stepIn                   // at the next statement
+	stepOver                 // at the next statement of the frame or a caller
+	stepOut                  // at the next statement of a caller
+)
+
+// DebugFrontend is the user interface of a Debugger.
+type DebugFrontend interface {
+	// Paused is called when the script pauses, because of reason "entry",
+	// "breakpoint" or "step". The script resumes when it returns and stops
+	// if it returns an error.
+	Paused(reason string) error
+}
+
+// Debugger runs a script statement by statement. It follows the execution
+// through the interpreter hooks, and hands over to its frontend whenever it
+// pauses.
+type Debugger struct {
+	interpreter *Interpreter
+	frontend    DebugFrontend
+	scopes      map[Stmt]*scope
+	stmtLines   map[int]bool // lines where a statement starts
+	lastLine    int
+
+	mu          sync.Mutex   // guards breakpoints, set while the script runs
+	breakpoints map[int]bool // lines to pause at
+
+	frames     []*debugFrame // innermost last
+	mode       stepMode
+	depth      int  // number of frames when stepping started
+	entry      bool // pause at the first statement
+	evaluating bool // no pause while evaluating an expression
+}
+
+// debugFrame is the call of a function, or the script itself.
+type debugFrame struct {
+	function *LoxFunction // nil for the script
+	active   []activeStmt // statements being executed, innermost last
+
+	// position of the last statement run, the one of a frame left by a
+	// call in tail position
+	last, lastCol int
+}
+
+type activeStmt struct {
+	stmt      Stmt
+	line, col int          // 0 if unknown
+	env       *Environment // environment the statement runs in
+}
+
+// NewDebugger returns a debugger of a script whose resolution recorded its
+// scopes.
+func NewDebugger(interpreter *Interpreter, resolution *Resolution) *Debugger {
+	d := &Debugger{
+		interpreter: interpreter,
+		scopes:      resolution.scopes,
+		stmtLines:   make(map[int]bool),
+		breakpoints: make(map[int]bool),
+	}
+	for stmt := range resolution.scopes {
+		if _, ok := stmt.(*StmtBlock); ok {
+			continue
+		}
+		if token, ok := nodeToken(stmt); ok {
+			d.stmtLines[token.row] = true
+			if token.row > d.lastLine {
+				d.lastLine = token.row
+			}
+		}
+	}
+	return d
+}
+
+func (d *Debugger) SetFrontend(frontend DebugFrontend) {
+	d.frontend = frontend
+}
+
+// Run interpretes statements, pausing before the first one if stopOnEntry.
+// It returns errQuit if the frontend stopped the script.
+func (d *Debugger) Run(statements []Stmt, stopOnEntry bool) error {
+	d.interpreter.SetHooks(d)
+	defer d.interpreter.SetHooks(nil)
+
+	d.frames = []*debugFrame{{}}
+	d.mode, d.entry = runMode, stopOnEntry
+	return d.interpreter.Interprete(statements)
+}
+
+var _ Hooks = &Debugger{}
+
+// Statement pauses before stmt if required.
+func (d *Debugger) Statement(stmt Stmt) error {
+	frame := d.frames[len(d.frames)-1]
+	line, col := 0, 0
+	if token, ok := nodeToken(stmt); ok {
+		line, col = token.Pos()
+	}
+	pause := !d.evaluating && frame.pausePoint(stmt, line)
+
+	frame.active = append(frame.active, activeStmt{stmt: stmt, line: line, col: col, env: d.interpreter.localEnv})
+	if line > 0 {
+		frame.last, frame.lastCol = line, col
+	}
+	if pause {
+		if reason := d.pauseReason(line); reason != "" {
+			return d.frontend.Paused(reason)
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) StatementDone(stmt Stmt) {
+	frame := d.frames[len(d.frames)-1]
+	frame.active = frame.active[:len(frame.active)-1]
+}
+
+func (d *Debugger) Call(fn *LoxFunction, args []interface{}) {
+	d.frames = append(d.frames, &debugFrame{function: fn})
+}
+
+func (d *Debugger) Return(fn *LoxFunction, value interface{}, err error) {
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+func (d *Debugger) Assign(name Token, value interface{}) {}
+
+func (d *Debugger) Error(err error) {}
+
+// pausePoint tells if the debugger may pause before stmt. Blocks are not
+// paused at, nor statements on the line of the statement enclosing them or
+// of the preceding statement of their block, so that a line is paused at
+// once each time it runs.
+func (f *debugFrame) pausePoint(stmt Stmt, line int) bool {
+	if _, ok := stmt.(*StmtBlock); ok || line == 0 {
+		return false
+	}
+	inner := stmt
+	for n := len(f.active) - 1; n >= 0; n-- {
+		block, ok := f.active[n].stmt.(*StmtBlock)
+		if ok && block.Statements[0] == inner {
+			// the line of a block is the one of its first statement
+			inner = block
+			continue
+		}
+		return f.active[n].line != line
+	}
+	return true
+}
+
+// pauseReason tells why the debugger pauses at line, if it does.
+func (d *Debugger) pauseReason(line int) string {
+	if d.entry {
+		d.entry = false
+		return "entry"
+	}
+	switch {
+	case d.mode == stepIn,
+		d.mode == stepOver && len(d.frames) <= d.depth,
+		d.mode == stepOut && len(d.frames) < d.depth:
+		return "step"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.breakpoints[line] {
+		return "breakpoint"
+	}
+	return ""
+}
+
+// Continue runs the script until a breakpoint when it resumes.
+func (d *Debugger) Continue() {
+	d.mode = runMode
+}
+
+// StepIn pauses at the next statement.
+func (d *Debugger) StepIn() {
+	d.mode = stepIn
+}
+
+// StepOver pauses at the next statement of the current function or of a
+// caller.
+func (d *Debugger) StepOver() {
+	d.mode, d.depth = stepOver, len(d.frames)
+}
+
+// StepOut pauses when the current function returns.
+func (d *Debugger) StepOut() {
+	d.mode, d.depth = stepOut, len(d.frames)
+}
+
+// SetBreakpoint sets a breakpoint at the first statement from line, it
+// returns the line of the breakpoint, or false if there is no statement.
+func (d *Debugger) SetBreakpoint(line int) (int, bool) {
+	for ; line <= d.lastLine; line++ {
+		if line > 0 && d.stmtLines[line] {
+			d.mu.Lock()
+			d.breakpoints[line] = true
+			d.mu.Unlock()
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+// DeleteBreakpoint deletes the breakpoint at line, if any.
+func (d *Debugger) DeleteBreakpoint(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	found := d.breakpoints[line]
+	delete(d.breakpoints, line)
+	return found
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	d.breakpoints = make(map[int]bool)
+	d.mu.Unlock()
+}
+
+// Breakpoints returns the sorted lines of the breakpoints.
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// line returns the line and column of the innermost statement executed by
+// the frame.
+func (f *debugFrame) line() (line, col int) {
+	for n := len(f.active) - 1; n >= 0; n-- {
+		if f.active[n].line > 0 {
+			return f.active[n].line, f.active[n].col
+		}
+	}
+	return f.last, f.lastCol
+}
+
+func (f *debugFrame) name() string {
+	if f.function == nil {
+		return "script"
+	}
+	return f.function.Name() + "()"
+}
+
+// frame returns the frame n calls out from the innermost.
+func (d *Debugger) frame(n int) *debugFrame {
+	return d.frames[len(d.frames)-1-n]
+}
+
+// frameScopes returns the environments of the innermost statement of a
+// frame, from the innermost, with the names of their defined slots.
+func (d *Debugger) frameScopes(frame *debugFrame) (envs []*Environment, names [][]string) {
+	if len(frame.active) == 0 {
+		return nil, nil
+	}
+	active := frame.active[len(frame.active)-1]
+	s := d.scopes[active.stmt]
+	for env := active.env; env != nil && s != nil; env, s = env.parent, s.parent {
+		n := len(s.names)
+		if len(env.values) < n {
+			n = len(env.values)
+		}
+		envs = append(envs, env)
+		names = append(names, s.names[:n])
+	}
+	return envs, names
+}
+
+// debugVariable is a variable in the scope of a frame.
+type debugVariable struct {
+	name  string
+	value interface{}
+}
+
+// locals returns the variables visible in frame n, from the innermost
+// scope, without the shadowed ones.
+func (d *Debugger) locals(n int) []debugVariable {
+	envs, names := d.frameScopes(d.frame(n))
+	seen := make(map[string]bool)
+	vars := make([]debugVariable, 0)
+	for n, env := range envs {
+		for slot, name := range names[n] {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			vars = append(vars, debugVariable{name: name, value: env.values[slot]})
+		}
+	}
+	return vars
+}
+
+// globals returns the globals defined by the script, not the natives.
+func (d *Debugger) globals() []debugVariable {
+	vars := make([]debugVariable, 0)
+	for name, value := range d.interpreter.globals {
+		if _, ok := value.(*BuildinFun); !ok {
+			vars = append(vars, debugVariable{name: name, value: value})
+		}
+	}
+	sort.Slice(vars, func(a, b int) bool { return vars[a].name < vars[b].name })
+	return vars
+}
+
+// evaluate evaluates an expression in the environment of frame n.
+func (d *Debugger) evaluate(n int, text string) (interface{}, error) {
+	tokens, errs := NewScanner(text).Scan()
+	if len(errs) > 0 {
+		return nil, errors.New(errs[0].Msg)
+	}
+	expr, err := NewParser(tokens).ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	envs, names := d.frameScopes(d.frame(n))
+	resolution, err := NewResolver().ResolveExpr(expr, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// the expression is resolved along with the script, and dropped once
+	// evaluated, with the inline caches filled meanwhile
+	i := d.interpreter
+	for e, local := range resolution.locals {
+		i.locals[e] = local
+	}
+	previous, caches := i.localEnv, i.caches
+	i.localEnv = nil
+	if len(envs) > 0 {
+		i.localEnv = envs[0]
+	}
+	i.caches = make(map[Expr]*InlineCache)
+	d.evaluating = true
+	defer func() {
+		for e := range resolution.locals {
+			delete(i.locals, e)
+		}
+		i.localEnv, i.caches = previous, caches
+		d.evaluating = false
+	}()
+	return i.eval(expr)
+}
+
+// debugConsole is the command line frontend of the debugger.
+type debugConsole struct {
+	d        *Debugger
+	filename string
+	lines    []string // source lines, from index 1
+	in       *bufio.Scanner
+	out      io.Writer
+	selected int    // frame inspected, counted from the innermost
+	last     string // command repeated by an empty line
+}
+
+var _ DebugFrontend = &debugConsole{}
+
+// Paused reads and runs commands until one resumes the execution.
+func (c *debugConsole) Paused(reason string) error {
+	c.selected = 0
+	c.printLocation()
+	for {
+		fmt.Fprint(c.out, "(golox) ")
+		if !c.in.Scan() {
+			fmt.Fprintln(c.out)
+			return errQuit
+		}
+		line := strings.TrimSpace(c.in.Text())
+		if line == "" {
+			line = c.last
+		}
+		if line == "" {
+			continue
+		}
+		c.last = line
+
+		resume, err := c.command(line)
+		if err != nil {
+			return err
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+const debugHelp = `break [line]    set a breakpoint, or list them (b)
+delete [line]   delete a breakpoint, or all of them (d)
+continue        run until a breakpoint (c)
+step            run to the next statement, entering calls (s)
+next            run to the next statement, over calls (n)
+finish          run until the current function returns (f)
+stack           list the calls (bt)
+frame n         select the frame to inspect (fr)
+locals          print the local variables of the frame
+globals         print the global variables
+print expr      evaluate an expression in the frame (p)
+list            print the source around the current line (l)
+quit            stop the script (q)
+`
+
+// command runs a command, it returns true if the script is to resume.
+func (c *debugConsole) command(line string) (resume bool, err error) {
+	name, arg := line, ""
+	if n := strings.IndexAny(line, " \t"); n >= 0 {
+		name, arg = line[:n], strings.TrimSpace(line[n+1:])
+	}
+
+	d := c.d
+	switch name {
+	case "break", "b":
+		c.setBreakpoint(arg)
+	case "delete", "d":
+		c.deleteBreakpoint(arg)
+	case "continue", "c":
+		d.Continue()
+		return true, nil
+	case "step", "s":
+		d.StepIn()
+		return true, nil
+	case "next", "n":
+		d.StepOver()
+		return true, nil
+	case "finish", "f":
+		d.StepOut()
+		return true, nil
+	case "stack", "bt":
+		c.printStack()
+	case "frame", "fr":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n >= len(d.frames) {
+			fmt.Fprintf(c.out, "No frame %q.\n", arg)
+			break
+		}
+		c.selected = n
+		c.printLocation()
+	case "locals":
+		c.printVariables(d.locals(c.selected), "No locals.")
+	case "globals":
+		c.printVariables(d.globals(), "No globals.")
+	case "print", "p":
+		value, err := d.evaluate(c.selected, arg)
+		if err != nil {
+			fmt.Fprintln(c.out, debugError(err))
+			break
+		}
+		fmt.Fprintln(c.out, debugString(value))
+	case "list", "l":
+		line, _ := d.frame(c.selected).line()
+		c.printSource(line, 5)
+	case "help", "h":
+		fmt.Fprint(c.out, debugHelp)
+	case "quit", "q":
+		return false, errQuit
+	default:
+		fmt.Fprintf(c.out, "Unknown command %q, try help.\n", name)
+	}
+	return false, nil
+}
+
+func (c *debugConsole) setBreakpoint(arg string) {
+	if arg == "" {
+		for _, line := range c.d.Breakpoints() {
+			fmt.Fprintf(c.out, "Breakpoint at line %d.\n", line)
+		}
+		return
+	}
+	line, err := strconv.Atoi(arg)
+	if err != nil || line < 1 {
+		fmt.Fprintf(c.out, "Invalid line %q.\n", arg)
+		return
+	}
+	if line, ok := c.d.SetBreakpoint(line); ok {
+		fmt.Fprintf(c.out, "Breakpoint at line %d.\n", line)
+		return
+	}
+	fmt.Fprintf(c.out, "No statement at line %s or after.\n", arg)
+}
+
+func (c *debugConsole) deleteBreakpoint(arg string) {
+	if arg == "" {
+		c.d.ClearBreakpoints()
+		return
+	}
+	line, err := strconv.Atoi(arg)
+	if err != nil || !c.d.DeleteBreakpoint(line) {
+		fmt.Fprintf(c.out, "No breakpoint at line %s.\n", arg)
+	}
+}
+
+func (c *debugConsole) printLocation() {
+	frame := c.d.frame(c.selected)
+	line, _ := frame.line()
+	fmt.Fprintf(c.out, "%s at %s:%d\n", frame.name(), c.filename, line)
+	c.printSource(line, 0)
+}
+
+// printSource prints the lines around line, within context lines.
+func (c *debugConsole) printSource(line, context int) {
+	breakpoints := make(map[int]bool)
+	for _, n := range c.d.Breakpoints() {
+		breakpoints[n] = true
+	}
+	for n := line - context; n <= line+context; n++ {
+		if n < 1 || n >= len(c.lines) {
+			continue
+		}
+		marker := "  "
+		if n == line {
+			marker = "->"
+		}
+		if breakpoints[n] {
+			marker = marker[:1] + "*"
+		}
+		fmt.Fprintf(c.out, "%s%4d  %s\n", marker, n, c.lines[n])
+	}
+}
+
+func (c *debugConsole) printStack() {
+	for n := range c.d.frames {
+		frame := c.d.frame(n)
+		marker := " "
+		if n == c.selected {
+			marker = "*"
+		}
+		line, _ := frame.line()
+		fmt.Fprintf(c.out, "%s#%d %s at %s:%d\n", marker, n, frame.name(), c.filename, line)
+	}
+}
+
+func (c *debugConsole) printVariables(vars []debugVariable, none string) {
+	for _, v := range vars {
+		fmt.Fprintf(c.out, "%s = %s\n", v.name, debugString(v.value))
+	}
+	if len(vars) == 0 {
+		fmt.Fprintln(c.out, none)
+	}
+}
+
+// debugError returns the message of an error of an evaluated expression,
+// without the position in the expression.
+func debugError(err error) string {
+	if e, ok := err.(*LoxError); ok && e.t == RuntimeError {
+		return e.msg
+	}
+	msg := err.Error()
+	if n := strings.IndexByte(msg, '\n'); n >= 0 {
+		msg = msg[:n]
+	}
+	return strings.TrimPrefix(msg, "error: ")
+}
+
+// debugString formats a value as it is written in a program.
+func debugString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case *LoxFunction:
+		return "<fn " + v.Name() + ">"
+	case *BuildinFun:
+		return "<native fn " + v.name + ">"
+	}
+	return loxString(value)
+}
+
+// debugCommand runs a script under the debugger, reading commands from the
+// standard input.
+func debugCommand(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s debug file\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("debug: expected a script")
+	}
+
+	if err := noObservers("debug"); err != nil {
+		return err
+	}
+
+	filename := flags.Arg(0)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	src := string(data)
+	logger.Reset(src, os.Stdout, os.Stderr)
+
+	tokens, err := NewScanner(src).Tokens()
+	if err != nil {
+		return err
+	}
+	statements, err := NewParser(tokens).Parse()
+	if err != nil {
+		return err
+	}
+	resolver := NewResolver()
+	resolver.RecordScopes()
+	resolution, err := resolver.Resolve(statements)
+	if err != nil {
+		return err
+	}
+
+	interpreter := NewInterpreter()
+	if err := configure(interpreter); err != nil {
+		return err
+	}
+	interpreter.SetResolution(resolution)
+
+	debugger := NewDebugger(interpreter, resolution)
+	debugger.SetFrontend(&debugConsole{
+		d:        debugger,
+		filename: filename,
+		lines:    append([]string{""}, strings.Split(src, "\n")...),
+		in:       bufio.NewScanner(os.Stdin),
+		out:      os.Stdout,
+	})
+	if err := debugger.Run(statements, true); err != nil {
+		if err == errQuit {
+			return nil
+		}
+		return err
+	}
+	fmt.Println("Program exited.")
+	return nil
+}
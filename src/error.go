@@ -13,9 +13,10 @@ const (
 )
 
 type LoxError struct {
-	t   LoxErrorType
-	msg string
-	tk  Token // used by parse error
+	t    LoxErrorType
+	msg  string
+	tk   Token // used by parse error
+	span Span  // of the source at fault, if more than the token
 }
 
 func NewLoxError(t LoxErrorType, tk Token, msg string) *LoxError {
@@ -40,9 +41,29 @@ func (e *LoxError) String() string {
 	default:
 		ret = "Unknown error type"
 	}
+	if !e.span.IsZero() {
+		if excerpt := logger.Excerpt(e.tk.row, e.tk.col, e.span); excerpt != "" {
+			ret += "\n" + excerpt
+		}
+	}
 	return ret
 }
 
+// spanning sets the source at fault to span, which is shown under the
+// message.
+func (e *LoxError) spanning(span Span) *LoxError {
+	e.span = span
+	return e
+}
+
+// Span returns the source at fault, the token if not set.
+func (e *LoxError) Span() Span {
+	if e.span.IsZero() {
+		return tokenSpan(e.tk)
+	}
+	return e.span
+}
+
 func (e *LoxError) Error() string {
 	return e.String()
 }
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formatter prints a program back as Lox source in the canonical style: two
+// spaces of indentation, opening braces on the line of their statement and
+// single spaces around binary operators.
+//
+// The formatter walks the syntax tree while following the tokens of the
+// source, which gives back what the tree does not keep: comments, single
+// blank lines between statements, the spelling of literals and the clauses
+// of for loops.
+type Formatter struct {
+	tokens   []Token
+	comments []Comment
+	next     int // next token of the source to write
+	comment  int // next comment of the source to write
+	lastRow  int // source row of the last token or comment written
+
+	out         bytes.Buffer
+	indent      int
+	atLineStart bool
+	stmtStart   bool // a blank line may be kept before the next token
+	continued   bool // a comment broke the statement, indented once more
+}
+
+var (
+	_ ExprVisitor = &Formatter{}
+	_ StmtVisitor = &Formatter{}
+)
+
+// Format returns src in the canonical style.
+func Format(src string) (string, error) {
+	logger.Reset(src, io.Discard, io.Discard)
+
+	scanner := NewScanner(src)
+	tokens, errs := scanner.Scan()
+	if len(errs) > 0 {
+		return "", errs[0]
+	}
+	statements, err := NewParser(tokens).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	f := &Formatter{tokens: tokens, comments: scanner.Comments(), atLineStart: true}
+	f.stmts(statements)
+	f.flushComments(math.MaxInt32)
+	if f.next != len(tokens)-1 {
+		return "", fmt.Errorf("[line %d] Error: formatted program diverges from the source", tokens[f.next].row)
+	}
+	return f.out.String(), nil
+}
+
+// write writes text, indented at the start of a line.
+func (f *Formatter) write(text string) {
+	if f.atLineStart {
+		indent := f.indent
+		if f.continued {
+			indent++
+		}
+		f.out.WriteString(strings.Repeat("  ", indent))
+		f.atLineStart = false
+	}
+	f.out.WriteString(text)
+}
+
+func (f *Formatter) space() {
+	f.write(" ")
+}
+
+func (f *Formatter) newline() {
+	f.out.WriteByte('\n')
+	f.atLineStart = true
+}
+
+// lineStart ends the current line if something was written on it, without
+// the space written after its last token.
+func (f *Formatter) lineStart() {
+	if !f.atLineStart {
+		f.trimSpaces()
+		f.newline()
+	}
+}
+
+func (f *Formatter) trimSpaces() {
+	for bytes.HasSuffix(f.out.Bytes(), []byte(" ")) {
+		f.out.Truncate(f.out.Len() - 1)
+	}
+}
+
+// blankLine writes an empty line, unless it would follow another one or an
+// opening brace.
+func (f *Formatter) blankLine() {
+	f.lineStart()
+	b := f.out.Bytes()
+	if len(b) == 0 || bytes.HasSuffix(b, []byte("\n\n")) || bytes.HasSuffix(b, []byte("{\n")) {
+		return
+	}
+	f.newline()
+}
+
+// token writes the next token of the source, text, with the comments
+// preceding it.
+func (f *Formatter) token(text string) {
+	if f.peek().lexeme == text {
+		tk := f.tokens[f.next]
+		if !f.stmtStart && f.comment < len(f.comments) && f.comments[f.comment].Offset < tk.offset {
+			// the comments break the line within a statement
+			f.continued = true
+		}
+		f.flushComments(tk.offset)
+		if f.stmtStart && f.lastRow > 0 && tk.row > f.lastRow+1 {
+			f.blankLine()
+		}
+		f.next++
+		f.lastRow = tk.row + strings.Count(tk.lexeme, "\n")
+	}
+	f.stmtStart = false
+	f.write(text)
+}
+
+func (f *Formatter) peek() Token {
+	if f.next < len(f.tokens) {
+		return f.tokens[f.next]
+	}
+	return Token{typ: EOF}
+}
+
+// flushComments writes the comments before offset. A comment following a
+// token on its line stays at the end of that line, others are on their own
+// line.
+func (f *Formatter) flushComments(offset int) {
+	for f.comment < len(f.comments) && f.comments[f.comment].Offset < offset {
+		c := f.comments[f.comment]
+		f.comment++
+		text := strings.TrimRight(c.Text, " \t\r")
+
+		if f.lastRow > 0 && c.Row == f.lastRow {
+			if f.atLineStart {
+				f.out.Truncate(f.out.Len() - 1)
+			}
+			f.trimSpaces()
+			f.atLineStart = false
+			f.out.WriteString(" " + text)
+			f.newline()
+			continue
+		}
+
+		f.lineStart()
+		if f.lastRow > 0 && c.Row > f.lastRow+1 {
+			f.blankLine()
+		}
+		f.write(text)
+		f.newline()
+		f.lastRow = c.Row
+	}
+}
+
+func (f *Formatter) expr(expr Expr) {
+	expr.Accept(f)
+}
+
+func (f *Formatter) stmt(stmt Stmt) {
+	stmt.Accept(f)
+}
+
+// stmts writes statements on lines of their own.
+func (f *Formatter) stmts(statements []Stmt) {
+	for _, statement := range statements {
+		f.lineStart()
+		f.stmtStart, f.continued = true, false
+		f.stmt(statement)
+	}
+	f.lineStart()
+}
+
+// braces writes n items between braces, {} if there are none and no
+// comments.
+func (f *Formatter) braces(n int, item func(int)) {
+	f.token("{")
+	if n == 0 && (f.comment == len(f.comments) || f.comments[f.comment].Offset > f.peek().offset) {
+		f.token("}")
+		return
+	}
+	f.newline()
+	f.indent++
+	for k := 0; k < n; k++ {
+		f.lineStart()
+		f.stmtStart, f.continued = true, false
+		item(k)
+	}
+	f.flushComments(f.peek().offset)
+	f.indent--
+	f.lineStart()
+	f.continued = false
+	f.token("}")
+}
+
+func (f *Formatter) block(statements []Stmt) {
+	f.braces(len(statements), func(k int) {
+		f.stmt(statements[k])
+	})
+}
+
+// isForLoop tells if a block is the scope of the initializer of a for loop,
+// rather than a block of the source.
+func (f *Formatter) isForLoop(stmt Stmt) bool {
+	block, ok := stmt.(*StmtBlock)
+	if !ok || len(block.Statements) != 2 || f.peek().typ != FOR {
+		return false
+	}
+	loop, ok := block.Statements[1].(*StmtWhile)
+	return ok && loop.Keyword.typ == FOR
+}
+
+// body writes the body of a control flow statement, on the same line when
+// it is not a block.
+func (f *Formatter) body(stmt Stmt) {
+	f.space()
+	if block, ok := stmt.(*StmtBlock); ok && !f.isForLoop(block) {
+		f.stmt(block)
+		return
+	}
+	f.indent++
+	f.stmt(stmt)
+	f.indent--
+}
+
+// forLoop writes a loop desugared by the parser as it was written.
+func (f *Formatter) forLoop(initializer Stmt, loop *StmtWhile) {
+	f.token("for")
+	f.space()
+	f.token("(")
+	if initializer != nil {
+		f.stmt(initializer)
+	} else {
+		f.token(";")
+	}
+	if f.peek().typ != SEMICOLON {
+		f.space()
+		f.expr(loop.Cond)
+	}
+	f.token(";")
+
+	body := loop.Body
+	if f.peek().typ != RIGHT_PAREN {
+		block := body.(*StmtBlock)
+		f.space()
+		f.expr(block.Statements[1].(*StmtExpression).Expression)
+		body = block.Statements[0]
+	}
+	f.token(")")
+	f.body(body)
+}
+
+// function writes the name, parameters and body of a function. Getters have
+// no parameter list.
+func (f *Formatter) function(fun *StmtFun, getter bool) {
+	f.token(fun.Name.lexeme)
+	if !getter {
+		f.token("(")
+		for n, param := range fun.Params {
+			if n > 0 {
+				f.token(",")
+				f.space()
+			}
+			f.token(param.lexeme)
+			if fun.Defaults[n] != nil {
+				f.space()
+				f.token("=")
+				f.space()
+				f.expr(fun.Defaults[n])
+			}
+		}
+		if fun.Rest != nil {
+			if len(fun.Params) > 0 {
+				f.token(",")
+				f.space()
+			}
+			f.token("...")
+			f.token(fun.Rest.lexeme)
+		}
+		f.token(")")
+	}
+	f.space()
+	f.block(fun.Body)
+}
+
+func (f *Formatter) VisitLiteral(expr *ExprLiteral) (interface{}, error) {
+	switch f.peek().typ {
+	case NUMBER, STRING, TRUE, FALSE, NIL:
+		f.token(f.peek().lexeme)
+		return nil, nil
+	}
+	switch value := expr.Value.(type) {
+	case nil:
+		f.write("nil")
+	case string:
+		f.write(`"` + value + `"`)
+	case float64:
+		f.write(strconv.FormatFloat(value, 'f', -1, 64))
+	default:
+		f.write(fmt.Sprint(value))
+	}
+	return nil, nil
+}
+
+func (f *Formatter) VisitVariable(expr *ExprVariable) (interface{}, error) {
+	f.token(expr.Name.lexeme)
+	return nil, nil
+}
+
+func (f *Formatter) VisitAssign(expr *ExprAssign) (interface{}, error) {
+	f.token(expr.Name.lexeme)
+	f.space()
+	f.token("=")
+	f.space()
+	f.expr(expr.Value)
+	return nil, nil
+}
+
+func (f *Formatter) VisitUnary(expr *ExprUnary) (interface{}, error) {
+	f.token(expr.UnaryOperator.lexeme)
+	f.expr(expr.Expression)
+	return nil, nil
+}
+
+func (f *Formatter) VisitGrouping(expr *ExprGrouping) (interface{}, error) {
+	f.token("(")
+	f.expr(expr.Expression)
+	f.token(")")
+	return nil, nil
+}
+
+func (f *Formatter) VisitBinary(expr *ExprBinary) (interface{}, error) {
+	f.expr(expr.Left)
+	f.space()
+	f.token(expr.Operator.lexeme)
+	f.space()
+	f.expr(expr.Right)
+	return nil, nil
+}
+
+func (f *Formatter) VisitLogical(expr *ExprLogical) (interface{}, error) {
+	f.expr(expr.Left)
+	f.space()
+	f.token(expr.Operator.lexeme)
+	f.space()
+	f.expr(expr.Right)
+	return nil, nil
+}
+
+func (f *Formatter) VisitCall(expr *ExprCall) (interface{}, error) {
+	f.expr(expr.Callee)
+	f.token("(")
+	for n, arg := range expr.Args {
+		if n > 0 {
+			f.token(",")
+			f.space()
+		}
+		f.expr(arg)
+	}
+	f.token(")")
+	return nil, nil
+}
+
+func (f *Formatter) VisitGet(expr *ExprGet) (interface{}, error) {
+	f.expr(expr.Object)
+	f.token(".")
+	f.token(expr.Field.lexeme)
+	return nil, nil
+}
+
+func (f *Formatter) VisitSet(expr *ExprSet) (interface{}, error) {
+	f.expr(expr.Object)
+	f.token(".")
+	f.token(expr.Field.lexeme)
+	f.space()
+	f.token("=")
+	f.space()
+	f.expr(expr.Value)
+	return nil, nil
+}
+
+func (f *Formatter) VisitThis(expr *ExprThis) (interface{}, error) {
+	f.token("this")
+	return nil, nil
+}
+
+func (f *Formatter) VisitSuper(expr *ExprSuper) (interface{}, error) {
+	f.token("super")
+	f.token(".")
+	f.token(expr.Method.lexeme)
+	return nil, nil
+}
+
+func (f *Formatter) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	f.expr(expr.Object)
+	f.token("[")
+	f.expr(expr.Index)
+	f.token("]")
+	return nil, nil
+}
+
+func (f *Formatter) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	f.token("...")
+	f.expr(expr.Expression)
+	return nil, nil
+}
+
+func (f *Formatter) VisitExpression(stmt *StmtExpression) (interface{}, error) {
+	f.expr(stmt.Expression)
+	f.token(";")
+	return nil, nil
+}
+
+func (f *Formatter) VisitPrint(stmt *StmtPrint) (interface{}, error) {
+	f.token("print")
+	f.space()
+	f.expr(stmt.Expression)
+	f.token(";")
+	return nil, nil
+}
+
+func (f *Formatter) VisitVar(stmt *StmtVar) (interface{}, error) {
+	f.token("var")
+	f.space()
+	f.token(stmt.Name.lexeme)
+	if stmt.Initializer != nil {
+		f.space()
+		f.token("=")
+		f.space()
+		f.expr(stmt.Initializer)
+	}
+	f.token(";")
+	return nil, nil
+}
+
+func (f *Formatter) VisitBlock(stmt *StmtBlock) (interface{}, error) {
+	if f.isForLoop(stmt) {
+		f.forLoop(stmt.Statements[0], stmt.Statements[1].(*StmtWhile))
+		return nil, nil
+	}
+	f.block(stmt.Statements)
+	return nil, nil
+}
+
+func (f *Formatter) VisitIf(stmt *StmtIf) (interface{}, error) {
+	f.token("if")
+	f.space()
+	f.token("(")
+	f.expr(stmt.Cond)
+	f.token(")")
+	_, braced := stmt.Then.(*StmtBlock)
+	braced = braced && !f.isForLoop(stmt.Then)
+	f.body(stmt.Then)
+	if stmt.Else == nil {
+		return nil, nil
+	}
+
+	if braced {
+		f.space()
+	} else {
+		f.lineStart()
+	}
+	f.token("else")
+	if elseIf, ok := stmt.Else.(*StmtIf); ok {
+		f.space()
+		f.stmt(elseIf)
+	} else {
+		f.body(stmt.Else)
+	}
+	return nil, nil
+}
+
+func (f *Formatter) VisitWhile(stmt *StmtWhile) (interface{}, error) {
+	if stmt.Keyword.typ == FOR {
+		f.forLoop(nil, stmt)
+		return nil, nil
+	}
+	f.token("while")
+	f.space()
+	f.token("(")
+	f.expr(stmt.Cond)
+	f.token(")")
+	f.body(stmt.Body)
+	return nil, nil
+}
+
+func (f *Formatter) VisitFun(stmt *StmtFun) (interface{}, error) {
+	f.token("fun")
+	f.space()
+	f.function(stmt, false)
+	return nil, nil
+}
+
+func (f *Formatter) VisitReturn(stmt *StmtReturn) (interface{}, error) {
+	f.token("return")
+	if stmt.Value != nil {
+		f.space()
+		f.expr(stmt.Value)
+	}
+	f.token(";")
+	return nil, nil
+}
+
+func (f *Formatter) VisitClass(stmt *StmtClass) (interface{}, error) {
+	f.token("class")
+	f.space()
+	f.token(stmt.Name.lexeme)
+	if stmt.Superclass != nil {
+		f.space()
+		f.token("<")
+		f.space()
+		f.expr(stmt.Superclass)
+	}
+	if len(stmt.Traits) > 0 {
+		f.space()
+		f.token("with")
+		f.space()
+		for n, trait := range stmt.Traits {
+			if n > 0 {
+				f.token(",")
+				f.space()
+			}
+			f.expr(trait)
+		}
+	}
+	f.space()
+
+	// members are grouped by kind in the tree, they are written in the
+	// order of the source
+	type member struct {
+		fun    *StmtFun
+		prefix string
+		getter bool
+	}
+	members := make([]member, 0)
+	for _, method := range stmt.Methods {
+		members = append(members, member{fun: method})
+	}
+	for _, method := range stmt.StaticMethods {
+		members = append(members, member{fun: method, prefix: "class"})
+	}
+	for _, getter := range stmt.Getters {
+		members = append(members, member{fun: getter, getter: true})
+	}
+	for _, setter := range stmt.Setters {
+		members = append(members, member{fun: setter, prefix: "set"})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].fun.Name.offset < members[j].fun.Name.offset
+	})
+
+	f.braces(len(members), func(k int) {
+		if members[k].prefix != "" {
+			f.token(members[k].prefix)
+			f.space()
+		}
+		f.function(members[k].fun, members[k].getter)
+	})
+	return nil, nil
+}
+
+func (f *Formatter) VisitTrait(stmt *StmtTrait) (interface{}, error) {
+	f.token("trait")
+	f.space()
+	f.token(stmt.Name.lexeme)
+	f.space()
+	f.braces(len(stmt.Methods), func(k int) {
+		f.function(stmt.Methods[k], false)
+	})
+	return nil, nil
+}
+
+// fmtCommand formats Lox source files, or the standard input.
+func fmtCommand(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "list the files which are not formatted and fail if there are any")
+	write := flags.Bool("write", false, "write the result to the files instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s fmt [flags] [files or directories...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *check && *write {
+		flags.Usage()
+		return fmt.Errorf("fmt: -check and -write are exclusive")
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			return fmt.Errorf("fmt: -write needs files")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		formatted, err := Format(string(data))
+		if err != nil {
+			return err
+		}
+		if *check {
+			if formatted != string(data) {
+				return fmt.Errorf("fmt: standard input is not formatted")
+			}
+			return nil
+		}
+		_, err = os.Stdout.WriteString(formatted)
+		return err
+	}
+
+	filenames, err := loxFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	failed, unformatted := 0, 0
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		formatted, err := Format(string(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			failed++
+			continue
+		}
+		switch {
+		case *check:
+			if formatted != string(data) {
+				fmt.Println(filename)
+				unformatted++
+			}
+		case *write:
+			if formatted != string(data) {
+				if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+					return err
+				}
+			}
+		default:
+			os.Stdout.WriteString(formatted)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("fmt: %d files could not be formatted", failed)
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("fmt: %d files are not formatted", unformatted)
+	}
+	return nil
+}
+
+// loxFiles returns the given files and the .lox files of the given
+// directories.
+func loxFiles(paths []string) ([]string, error) {
+	filenames := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && filepath.Ext(name) == ".lox" {
+				filenames = append(filenames, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filenames, nil
+}
@@ -2,33 +2,55 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
 type LoxCallable interface {
-	Arity() int
+	// Arity returns the minimum and maximum number of arguments accepted,
+	// max is -1 if the callable accepts any number of extra arguments.
+	Arity() (min, max int)
 	Call(*Interpreter, []interface{}) (interface{}, error)
 }
 
+// checkArity reports whether got arguments are accepted by arity min and max.
+func checkArity(min, max, got int) bool {
+	return got >= min && (max < 0 || got <= max)
+}
+
+// arityError returns the message for a call with a wrong number of arguments.
+func arityError(min, max, got int) string {
+	switch {
+	case min == max:
+		return fmt.Sprintf("Expected %d arguments but got %d.", min, got)
+	case max < 0:
+		return fmt.Sprintf("Expected at least %d arguments but got %d.", min, got)
+	default:
+		return fmt.Sprintf("Expected %d to %d arguments but got %d.", min, max, got)
+	}
+}
+
 // builtin
 type BuildinFun struct {
-	name  string
-	arity int
-	call  func(*Interpreter, []interface{}) (interface{}, error)
+	name       string
+	arity      int
+	capability Capability // capability group required in sandbox mode
+	call       func(*Interpreter, []interface{}) (interface{}, error)
 }
 
 func (b *BuildinFun) Call(i *Interpreter, args []interface{}) (interface{}, error) {
 	return b.call(i, args)
 }
 
-func (b *BuildinFun) Arity() int {
-	return b.arity
+func (b *BuildinFun) Arity() (min, max int) {
+	return b.arity, b.arity
 }
 
 // clock
 var BuildinClock *BuildinFun = &BuildinFun{
-	name:  "clock",
-	arity: 0,
+	name:       "clock",
+	arity:      0,
+	capability: CapTime,
 	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
 		return float64(time.Now().Unix()), nil
 	},
@@ -36,14 +58,20 @@ var BuildinClock *BuildinFun = &BuildinFun{
 
 // sleep
 var BuildinSleep *BuildinFun = &BuildinFun{
-	name:  "sleep",
-	arity: 1,
+	name:       "sleep",
+	arity:      1,
+	capability: CapTime,
 	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
 		f, ok := args[0].(float64)
 		if !ok {
 			return nil, errors.New("sleep only accept float64 as arg")
 		}
-		time.Sleep(time.Duration(f) * time.Second)
+		// wake up early if the execution is stopped
+		select {
+		case <-time.After(time.Duration(f) * time.Second):
+		case <-i.runCtx.Done():
+			return nil, i.checkContext()
+		}
 		return nil, nil
 	},
 }
@@ -52,7 +80,9 @@ var BuildinSleep *BuildinFun = &BuildinFun{
 type LoxFunction struct {
 	definition    *StmtFun
 	closure       *Environment
-	isInitializer bool // is class initializer
+	isInitializer bool         // is class initializer
+	isMethod      bool         // this is in the first slot of its frame
+	this          *LoxInstance // instance a method is bound to
 }
 
 func NewLoxFunction(definition *StmtFun, closure *Environment, isInitializer bool) *LoxFunction {
@@ -63,40 +93,130 @@ func NewLoxFunction(definition *StmtFun, closure *Environment, isInitializer boo
 	}
 }
 
-func (f *LoxFunction) Arity() int {
-	return len(f.definition.Params)
+// NewLoxMethod returns a method of a class or trait, which is called with
+// an instance as this.
+func NewLoxMethod(definition *StmtFun, closure *Environment, isInitializer bool) *LoxFunction {
+	fn := NewLoxFunction(definition, closure, isInitializer)
+	fn.isMethod = true
+	return fn
 }
 
-func (f *LoxFunction) Call(i *Interpreter, args []interface{}) (ret interface{}, err error) {
-	env := NewEnvironment(f.closure)
-	params := f.definition.Params
-	for i := range args {
-		env.Define(params[i], args[i])
+// Name returns the name the function is declared with.
+func (f *LoxFunction) Name() string {
+	return f.definition.Name.lexeme
+}
+
+func (f *LoxFunction) Arity() (min, max int) {
+	return declArity(f.definition)
+}
+
+// declArity returns the arity of a function declaration.
+func declArity(def *StmtFun) (min, max int) {
+	for _, value := range def.Defaults {
+		if value == nil {
+			min++
+		}
+	}
+	if def.Rest != nil {
+		return min, -1
 	}
+	return min, len(def.Params)
+}
 
-	// return this if f is init function
+// bindParams defines the parameters of f in env, evaluating the default
+// values of missing arguments in env and collecting extra arguments into the
+// rest parameter.
+func (f *LoxFunction) bindParams(i *Interpreter, env *Environment, args []interface{}) error {
+	previous := i.localEnv
+	i.localEnv = env
 	defer func() {
-		if f.isInitializer {
-			ret, _ = f.closure.Get("this", 1)
-		}
+		i.localEnv = previous
 	}()
 
-	// catch return value
-	defer func() {
-		r := recover()
-		if r == nil {
-			return
+	params := f.definition.Params
+	for n := range params {
+		if n < len(args) {
+			env.Define(args[n])
+			continue
+		}
+		value, err := i.eval(f.definition.Defaults[n])
+		if err != nil {
+			return err
+		}
+		env.Define(value)
+	}
+
+	if f.definition.Rest != nil {
+		rest := make([]interface{}, 0)
+		if len(args) > len(params) {
+			rest = append(rest, args[len(params):]...)
+		}
+		if err := i.alloc(MemCollections, listSize+len(rest)*valueSize); err != nil {
+			return err
 		}
+		env.Define(NewLoxList(rest))
+	}
+	return nil
+}
+
+func (f *LoxFunction) Call(i *Interpreter, args []interface{}) (interface{}, error) {
+	return f.invoke(i, f.this, args)
+}
 
-		retval, isReturn := r.(*Return)
-		if !isReturn {
-			panic(r)
+// invoke calls f with this bound to the instance this, if not nil.
+func (f *LoxFunction) invoke(i *Interpreter, this *LoxInstance, args []interface{}) (ret interface{}, err error) {
+	if err := i.enterCall(); err != nil {
+		return nil, err
+	}
+	defer i.leaveCall()
+
+	if i.hooks != nil {
+		i.hooks.Call(f, args)
+		// f is the last function called in tail position
+		defer func() { i.hooks.Return(f, ret, err) }()
+	}
+
+	// calls in tail position run in this loop, in place of the caller
+	for {
+		size := i.sizes[f.definition]
+		if err := i.alloc(MemEnvironments, environmentSize+size*valueSize); err != nil {
+			return nil, err
+		}
+		env := NewEnvironment(f.closure, size)
+		if f.isMethod {
+			env.Define(this)
+		}
+		if err := f.bindParams(i, env, args); err != nil {
+			return nil, err
 		}
 
-		ret = retval.Value
-	}()
+		c, err := i.execBlock(f.definition.Body, env)
+		if err != nil {
+			return nil, err
+		}
+
+		if c == TailCallCompletion {
+			if i.hooks != nil {
+				i.hooks.Return(f, TailCalled, nil)
+			}
+			f, this, args = i.tailcall.function, i.tailcall.this, i.tailcall.args
+			i.tailcall = tailCall{}
+			if i.hooks != nil {
+				i.hooks.Call(f, args)
+			}
+			continue
+		}
 
-	i.execBlock(f.definition.Body, env)
+		// return this if f is init function
+		if f.isInitializer {
+			return env.values[thisSlot], nil
+		}
 
-	return
+		if c == ReturnCompletion {
+			ret := i.retval
+			i.retval = nil
+			return ret, nil
+		}
+		return nil, nil
+	}
 }
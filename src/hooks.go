@@ -0,0 +1,63 @@
+package main
+
+// Hooks observe the execution of a script, see Interpreter.SetHooks. Calls
+// are only reported for the functions of the script, not for natives. A
+// call in tail position replaces its caller: the caller is reported to
+// return TailCalled before the call is reported.
+type Hooks interface {
+	// Statement is called before a statement runs, an error stops the
+	// script. StatementDone is called after it ran.
+	Statement(stmt Stmt) error
+	StatementDone(stmt Stmt)
+
+	// Call is called when a function is called, before its parameters are
+	// bound. Return is called when it returns value, or fails with err.
+	Call(fn *LoxFunction, args []interface{})
+	Return(fn *LoxFunction, value interface{}, err error)
+
+	// Assign is called when a value is assigned to a variable.
+	Assign(name Token, value interface{})
+
+	// Error is called with the error which stopped a script.
+	Error(err error)
+}
+
+// TailCalled is the value reported to Hooks.Return for a function replaced
+// by a call in tail position.
+var TailCalled = &tailCalled{}
+
+type tailCalled struct{}
+
+func (t *tailCalled) String() string {
+	return "<tail call>"
+}
+
+// BranchHooks are hooks also notified of the branches taken.
+type BranchHooks interface {
+	Hooks
+
+	// Branch is called when the condition of an if or while statement, or
+	// the left operand of and or or, is evaluated. taken tells if the then
+	// branch, the loop body or the right operand runs next.
+	Branch(node interface{}, taken bool)
+}
+
+// SetHooks sets the hooks notified of the execution, nil for none. Hooks
+// which are also BranchHooks are notified of the branches.
+func (i *Interpreter) SetHooks(hooks Hooks) {
+	i.hooks = hooks
+	i.branches, _ = hooks.(BranchHooks)
+}
+
+// executeHooked executes statement, notifying the hooks.
+func (i *Interpreter) executeHooked(statement Stmt) (Completion, error) {
+	if err := i.hooks.Statement(statement); err != nil {
+		return NormalCompletion, err
+	}
+	c, err := statement.Accept(i)
+	i.hooks.StatementDone(statement)
+	if err != nil {
+		return NormalCompletion, err
+	}
+	return c.(Completion), nil
+}
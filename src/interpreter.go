@@ -1,54 +1,123 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 )
 
+// Environment holds the variables of a block or function call in slots
+// assigned by the resolver.
 type Environment struct {
 	parent *Environment
-	values map[string]interface{}
+	values []interface{}
 }
 
-func NewEnvironment(parent *Environment) *Environment {
+// Environments with few slots are allocated along with their slots.
+type (
+	environment1 struct {
+		Environment
+		slots [1]interface{}
+	}
+	environment2 struct {
+		Environment
+		slots [2]interface{}
+	}
+	environment3 struct {
+		Environment
+		slots [3]interface{}
+	}
+)
+
+func NewEnvironment(parent *Environment, size int) *Environment {
+	switch size {
+	case 0:
+		return &Environment{parent: parent}
+	case 1:
+		env := &environment1{}
+		env.parent, env.values = parent, env.slots[:0]
+		return &env.Environment
+	case 2:
+		env := &environment2{}
+		env.parent, env.values = parent, env.slots[:0]
+		return &env.Environment
+	case 3:
+		env := &environment3{}
+		env.parent, env.values = parent, env.slots[:0]
+		return &env.Environment
+	}
 	return &Environment{
 		parent: parent,
-		values: make(map[string]interface{}),
+		values: make([]interface{}, 0, size),
 	}
 }
 
-func (env *Environment) Define(name string, value interface{}) {
-	env.values[name] = value
+// Define puts value in the next slot. Declarations are executed in the order
+// the resolver assigned their slots.
+func (env *Environment) Define(value interface{}) {
+	env.values = append(env.values, value)
 }
 
-func (env *Environment) Set(name string, depth int, value interface{}) bool {
-	if depth > 0 {
-		return env.parent.Set(name, depth-1, value)
+func (env *Environment) ancestor(depth int) *Environment {
+	for ; depth > 0; depth-- {
+		env = env.parent
 	}
+	return env
+}
 
-	if _, found := env.values[name]; found {
-		env.values[name] = value
-		return true
-	}
+func (env *Environment) Set(depth, slot int, value interface{}) {
+	env.ancestor(depth).values[slot] = value
+}
 
-	return false
+func (env *Environment) Get(depth, slot int) interface{} {
+	return env.ancestor(depth).values[slot]
 }
 
-func (env *Environment) Get(name string, depth int) (interface{}, bool) {
-	if depth > 0 {
-		return env.parent.Get(name, depth-1)
-	}
+// thisSlot is the slot of this in the frame of a method. super is in the
+// environment enclosing the methods of a class with a superclass.
+const thisSlot = 0
 
-	if value, found := env.values[name]; found {
-		return value, true
-	}
+// Completion tells how a statement finished executing. It is the value
+// returned by statement visitors, so that control flow leaving a statement
+// early is propagated by execute and execBlock.
+type Completion int
 
-	return nil, false
-}
+const (
+	NormalCompletion   Completion = iota
+	ReturnCompletion              // the returned value is in Interpreter.retval
+	TailCallCompletion            // the function in Interpreter.tailcall is to be called
+)
 
 type Interpreter struct {
-	globalEnv *Environment
-	localEnv  *Environment
-	locals    map[Expr]int
+	globals  map[string]interface{}
+	localEnv *Environment // nil at global scope
+	locals   map[Expr]Local
+	sizes    map[Stmt]int
+	retval   interface{} // value of the last executed return statement
+	tails    map[*StmtReturn]bool
+	tailcall tailCall // call of the last executed tail return
+	caches   map[Expr]*InlineCache
+
+	// resource limits
+	limits Limits
+	ctx    context.Context // set by the host
+	runCtx context.Context // ctx with the timeout of the current run
+	steps  int
+	depth  int
+
+	sandbox *Sandbox // nil if natives are unrestricted
+
+	strings concatBuffer
+
+	stdout io.Writer // output of print statements
+
+	hooks    Hooks       // nil unless the execution is observed
+	branches BranchHooks // hooks if they observe branches
+
+	// memory accounting
+	mem       MemStats
+	allocated int64 // total, checked against Limits.AllocBudget
 }
 
 var (
@@ -57,61 +126,119 @@ var (
 )
 
 func NewInterpreter() *Interpreter {
-	global := NewEnvironment(nil)
-	global.Define("clock", BuildinClock)
-	global.Define("sleep", BuildinSleep)
+	global := make(map[string]interface{})
+	global["clock"] = BuildinClock
+	global["sleep"] = BuildinSleep
+	global["len"] = BuildinLen
+	global["memoryUsage"] = BuildinMemoryUsage
+
+	// introspection
+	global["type"] = BuildinType
+	global["instanceOf"] = BuildinInstanceOf
+	global["classOf"] = BuildinClassOf
+	global["className"] = BuildinClassName
+	global["superclassOf"] = BuildinSuperclassOf
+	global["fields"] = BuildinFields
+	global["methods"] = BuildinMethods
+	global["hasField"] = BuildinHasField
+	global["getField"] = BuildinGetField
+	global["setField"] = BuildinSetField
+	global["arity"] = BuildinArity
 
 	return &Interpreter{
-		globalEnv: global,
-		localEnv:  NewEnvironment(nil),
+		globals: global,
+		locals:  make(map[Expr]Local),
+		sizes:   make(map[Stmt]int),
+		tails:   make(map[*StmtReturn]bool),
+		caches:  make(map[Expr]*InlineCache),
+		limits:  DefaultLimits,
+		stdout:  os.Stdout,
+		ctx:     context.Background(),
+		runCtx:  context.Background(),
 	}
 }
 
 func (i *Interpreter) Interprete(statements []Stmt) error {
-	defer func() {
-		r := recover()
-		if r != nil {
-			fmt.Println(r)
-		}
-	}()
+	i.runCtx = i.ctx
+	if i.limits.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(i.ctx, i.limits.Timeout)
+		defer cancel()
+		i.runCtx = ctx
+	}
+	i.steps, i.depth = 0, 0
+	i.mem, i.allocated = MemStats{}, 0
 
 	for _, statement := range statements {
-		err := i.execute(statement)
-		if err != nil {
+		if _, err := i.execute(statement); err != nil {
+			err = atStatement(err, statement)
+			if i.hooks != nil {
+				i.hooks.Error(err)
+			}
 			return err
 		}
 	}
 	return nil
 }
 
-func (i *Interpreter) SetLocals(locals map[Expr]int) {
-	i.locals = locals
+// SetOutput sets the writer print statements write to.
+func (i *Interpreter) SetOutput(w io.Writer) {
+	i.stdout = w
+}
+
+func (i *Interpreter) SetResolution(resolution *Resolution) {
+	i.locals = resolution.locals
+	i.sizes = resolution.sizes
+	i.tails = resolution.tails
+	i.caches = make(map[Expr]*InlineCache)
+}
+
+// define declares a variable in the current scope.
+func (i *Interpreter) define(name string, value interface{}) {
+	if i.localEnv == nil {
+		i.globals[name] = value
+		return
+	}
+	i.localEnv.Define(value)
 }
 
 func (i *Interpreter) getVariable(expr Expr, name string) (val interface{}, ok bool) {
-	depth, resolved := i.locals[expr]
-	if resolved {
-		return i.localEnv.Get(name, depth)
+	if local, resolved := i.locals[expr]; resolved {
+		return i.localEnv.Get(local.depth, local.slot), true
 	}
-	return i.globalEnv.Get(name, 0)
+	val, ok = i.globals[name]
+	return val, ok
 }
 
 func (i *Interpreter) setVariable(expr Expr, name string, val interface{}) bool {
-	depth, resolved := i.locals[expr]
-	if resolved {
-		return i.localEnv.Set(name, depth, val)
+	if local, resolved := i.locals[expr]; resolved {
+		i.localEnv.Set(local.depth, local.slot, val)
+		return true
+	}
+	if _, ok := i.globals[name]; !ok {
+		return false
 	}
-	return i.globalEnv.Set(name, 0, val)
+	i.globals[name] = val
+	return true
 }
 
-func (i *Interpreter) execute(statement Stmt) error {
-	_, err := statement.Accept(i)
-	return err
+func (i *Interpreter) execute(statement Stmt) (Completion, error) {
+	if err := i.step(); err != nil {
+		return NormalCompletion, err
+	}
+	if i.hooks != nil {
+		return i.executeHooked(statement)
+	}
+	c, err := statement.Accept(i)
+	if err != nil {
+		return NormalCompletion, err
+	}
+	return c.(Completion), nil
 }
 
-func (i *Interpreter) runtimeError(token Token, msg string) error {
+// runtimeError returns an error about expr, pointing at token.
+func (i *Interpreter) runtimeError(expr Expr, token Token, msg string) error {
 	row, col := token.Pos()
-	return logger.NewError(row, col, msg)
+	return logger.NewSpanError(row, col, expr.Span(), msg)
 }
 
 func checkNumOperands(operands ...interface{}) bool {
@@ -132,6 +259,28 @@ func checkStringOperands(operands ...interface{}) bool {
 	return true
 }
 
+// loxString formats a runtime value the way print shows it.
+func loxString(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprint(value)
+}
+
+// isEqual compares values of any type, objects are equal if they are the
+// same object.
+func isEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return ok && a == b
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	}
+	return a == b
+}
+
 func isTruthy(obj interface{}) bool {
 	if obj == nil {
 		return false
@@ -143,6 +292,9 @@ func isTruthy(obj interface{}) bool {
 }
 
 func (i *Interpreter) eval(expr Expr) (interface{}, error) {
+	if err := i.step(); err != nil {
+		return nil, err
+	}
 	return expr.Accept(i)
 }
 
@@ -154,9 +306,9 @@ func (i *Interpreter) VisitVariable(expr *ExprVariable) (interface{}, error) {
 	name := expr.Name.Value().(string)
 	value, find := i.getVariable(expr, name)
 	if !find {
-		panic(NewLoxError(RuntimeError, expr.Name,
+		return nil, NewLoxError(RuntimeError, expr.Name,
 			fmt.Sprintf("Undefined variable '%s'.", name),
-		))
+		)
 	}
 	return value, nil
 }
@@ -169,9 +321,12 @@ func (i *Interpreter) VisitAssign(expr *ExprAssign) (interface{}, error) {
 	}
 
 	if succ := i.setVariable(expr, name, value); !succ {
-		panic(NewLoxError(RuntimeError, expr.Name,
+		return nil, NewLoxError(RuntimeError, expr.Name,
 			fmt.Sprintf("Undefined variable '%s'.", name),
-		))
+		)
+	}
+	if i.hooks != nil {
+		i.hooks.Assign(expr.Name, value)
 	}
 
 	return value, nil
@@ -186,7 +341,7 @@ func (i *Interpreter) VisitUnary(expr *ExprUnary) (interface{}, error) {
 	switch expr.UnaryOperator.Type() {
 	case MINUS:
 		if !checkNumOperands(right) {
-			return nil, i.runtimeError(expr.UnaryOperator, "operand of - must be a number")
+			return nil, i.runtimeError(expr, expr.UnaryOperator, "operand of - must be a number")
 		}
 		return -right.(float64), nil
 	case BANG:
@@ -211,121 +366,370 @@ func (i *Interpreter) VisitBinary(expr *ExprBinary) (interface{}, error) {
 		return nil, err
 	}
 
+	if isInstance(left) || isInstance(right) {
+		return i.binaryOverload(expr, left, right)
+	}
+
 	switch expr.Operator.Type() {
 	case PLUS:
 		if checkNumOperands(left, right) {
 			return left.(float64) + right.(float64), nil
 		}
 		if checkStringOperands(left, right) {
-			return left.(string) + right.(string), nil
+			s, allocated := i.strings.concat(left.(string), right.(string))
+			if err := i.alloc(MemStrings, stringHeaderSize+allocated); err != nil {
+				return nil, atToken(err, expr.Operator)
+			}
+			return s, nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of + must be two strings or two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of + must be two strings or two numbers")
 	case MINUS:
 		if checkNumOperands(left, right) {
 			return left.(float64) - right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of - must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of - must be two numbers")
 	case STAR:
 		if checkNumOperands(left, right) {
 			return left.(float64) * right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of * must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of * must be two numbers")
 	case SLASH:
 		if checkNumOperands(left, right) {
 			return left.(float64) / right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of / must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of / must be two numbers")
 	case GREATER:
 		if checkNumOperands(left, right) {
 			return left.(float64) > right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of > must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of > must be two numbers")
 	case GREATER_EQUAL:
 		if checkNumOperands(left, right) {
 			return left.(float64) >= right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of >= must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of >= must be two numbers")
 	case LESS:
 		if checkNumOperands(left, right) {
 			return left.(float64) < right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of < must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of < must be two numbers")
 	case LESS_EQUAL:
 		if checkNumOperands(left, right) {
 			return left.(float64) <= right.(float64), nil
 		}
-		return nil, i.runtimeError(expr.Operator, "operands of <= must be two numbers")
+		return nil, i.runtimeError(expr, expr.Operator, "operands of <= must be two numbers")
 
-	// FIXME: Support == and != operator for other objects
 	case EQUAL_EQUAL:
-		if checkNumOperands(left, right) {
-			return left.(float64) == right.(float64), nil
-		}
-		return nil, i.runtimeError(expr.Operator, "operands of == must be two numbers")
+		return isEqual(left, right), nil
 	case BANG_EQUAL:
-		if checkNumOperands(left, right) {
-			return left.(float64) != right.(float64), nil
-		}
-		return nil, i.runtimeError(expr.Operator, "operands of != must be two numbers")
+		return !isEqual(left, right), nil
 
 	default:
 		panic("golox error: invalid binary operator type")
 	}
 }
 
+// operatorMethods maps overloadable binary operators to the special methods
+// implementing them. != is implemented as the negation of __eq__.
+var operatorMethods = map[TokenType]string{
+	PLUS:          "__add__",
+	MINUS:         "__sub__",
+	STAR:          "__mul__",
+	SLASH:         "__div__",
+	GREATER:       "__gt__",
+	GREATER_EQUAL: "__ge__",
+	LESS:          "__lt__",
+	LESS_EQUAL:    "__le__",
+	EQUAL_EQUAL:   "__eq__",
+	BANG_EQUAL:    "__eq__",
+}
+
+// reflectedMethods maps overloadable binary operators to the special methods
+// of the right operand tried when the left one does not implement the
+// operator, with the left operand as argument: 2 * v calls v.__rmul__(2) and
+// 2 < v calls v.__gt__(2).
+var reflectedMethods = map[TokenType]string{
+	PLUS:          "__radd__",
+	MINUS:         "__rsub__",
+	STAR:          "__rmul__",
+	SLASH:         "__rdiv__",
+	GREATER:       "__lt__",
+	GREATER_EQUAL: "__le__",
+	LESS:          "__gt__",
+	LESS_EQUAL:    "__ge__",
+	EQUAL_EQUAL:   "__eq__",
+	BANG_EQUAL:    "__eq__",
+}
+
+func isInstance(value interface{}) bool {
+	_, ok := value.(*LoxInstance)
+	return ok
+}
+
+// callSpecial calls the special method name on obj with args. found is false
+// if the class of obj does not define the method.
+func (i *Interpreter) callSpecial(obj *LoxInstance, name string, tk Token, args ...interface{}) (ret interface{}, found bool, err error) {
+	fn := obj.class.FindMethod(name)
+	if fn == nil {
+		return nil, false, nil
+	}
+	if min, max := fn.Arity(); !checkArity(min, max, len(args)) {
+		return nil, true, NewLoxError(RuntimeError, tk,
+			fmt.Sprintf("Special method '%s' must accept %d arguments.", name, len(args)))
+	}
+	ret, err = fn.invoke(i, obj, args)
+	return ret, true, err
+}
+
+// binaryOverload applies a binary operator to operands of which at least one
+// is an instance, calling the special method of the left operand or else the
+// reflected one of the right operand.
+func (i *Interpreter) binaryOverload(expr *ExprBinary, left, right interface{}) (interface{}, error) {
+	var ret interface{}
+	var found bool
+	var err error
+	if obj, ok := left.(*LoxInstance); ok {
+		ret, found, err = i.callSpecial(obj, operatorMethods[expr.Operator.Type()], expr.Operator, right)
+	}
+	if obj, ok := right.(*LoxInstance); ok && !found {
+		ret, found, err = i.callSpecial(obj, reflectedMethods[expr.Operator.Type()], expr.Operator, left)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// instances without __eq__ are only equal to themselves
+		switch expr.Operator.Type() {
+		case EQUAL_EQUAL:
+			return isEqual(left, right), nil
+		case BANG_EQUAL:
+			return !isEqual(left, right), nil
+		}
+		obj := left
+		if !isInstance(left) {
+			obj = right
+		}
+		return nil, NewLoxError(RuntimeError, expr.Operator,
+			fmt.Sprintf("Undefined operator '%s' for %s.", expr.Operator.lexeme, obj))
+	}
+	if expr.Operator.Type() == BANG_EQUAL {
+		return !isTruthy(ret), nil
+	}
+	return ret, nil
+}
+
 func (i *Interpreter) VisitLogical(expr *ExprLogical) (interface{}, error) {
 	left, err := i.eval(expr.Left)
 	if err != nil {
 		return nil, err
 	}
 
-	if expr.Operator.Type() == OR {
-		if isTruthy(left) {
-			return left, nil
-		}
-	} else {
-		if !isTruthy(left) {
-			return left, nil
-		}
+	// the right operand is skipped if the left one decides
+	skip := isTruthy(left)
+	if expr.Operator.Type() != OR {
+		skip = !skip
+	}
+	if i.branches != nil {
+		i.branches.Branch(expr, !skip)
+	}
+	if skip {
+		return left, nil
 	}
 
 	return i.eval(expr.Right)
 }
 
 func (i *Interpreter) VisitCall(expr *ExprCall) (interface{}, error) {
-	callee, err := i.eval(expr.Callee)
+	function, this, args, err := i.evalCall(expr)
 	if err != nil {
 		return nil, err
 	}
 
+	ret, err := i.call(function, this, args)
+	if err != nil {
+		return nil, atToken(err, expr.Paren)
+	}
+	return ret, nil
+}
+
+// tailCall is a call made by a function as its last action.
+type tailCall struct {
+	function *LoxFunction
+	this     *LoxInstance // receiver of a method call
+	args     []interface{}
+}
+
+// tailReturn returns the result of a call in tail position. Calls to Lox
+// functions are left to the loop in LoxFunction.Call, which reuses the
+// current frame instead of growing the stack.
+func (i *Interpreter) tailReturn(expr *ExprCall) (interface{}, error) {
+	function, this, args, err := i.evalCall(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if fn, ok := function.(*LoxFunction); ok {
+		if this == nil {
+			this = fn.this
+		}
+		i.tailcall = tailCall{function: fn, this: this, args: args}
+		return TailCallCompletion, nil
+	}
+
+	ret, err := i.call(function, this, args)
+	if err != nil {
+		return nil, atToken(err, expr.Paren)
+	}
+	i.retval = ret
+	return ReturnCompletion, nil
+}
+
+// evalCall evaluates the callee and the arguments of a call and checks the
+// call can be made.
+func (i *Interpreter) evalCall(expr *ExprCall) (LoxCallable, *LoxInstance, []interface{}, error) {
+	callee, this, err := i.evalCallee(expr.Callee)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// instances are callable through their __call__ method
+	if obj, ok := callee.(*LoxInstance); ok {
+		if fn := obj.class.FindMethod("__call__"); fn != nil {
+			callee, this = fn, obj
+		}
+	}
+
 	function, callable := callee.(LoxCallable)
 	if !callable {
-		panic(NewLoxError(RuntimeError, expr.Paren, "Can only call functions and classes."))
+		return nil, nil, nil, NewLoxError(RuntimeError, expr.Paren, "Can only call functions and classes.").spanning(expr.Callee.Span())
 	}
 
 	args := make([]interface{}, 0)
 	for _, arg := range expr.Args {
+		if spread, ok := arg.(*ExprSpread); ok {
+			value, err := i.eval(spread.Expression)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			list, ok := value.(*LoxList)
+			if !ok {
+				return nil, nil, nil, NewLoxError(RuntimeError, spread.Ellipsis, "Can only spread lists.")
+			}
+			args = append(args, list.elements...)
+			continue
+		}
 		value, err := i.eval(arg)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 		args = append(args, value)
 	}
 
-	expect, got := function.Arity(), len(args)
-	if expect != got {
-		panic(NewLoxError(RuntimeError, expr.Paren,
-			fmt.Sprintf("Expected %d arguments but got %d.", expect, got)))
+	if min, max := function.Arity(); !checkArity(min, max, len(args)) {
+		return nil, nil, nil, NewLoxError(RuntimeError, expr.Paren, arityError(min, max, len(args))).spanning(expr.Span())
+	}
+
+	if err := i.checkContext(); err != nil {
+		return nil, nil, nil, atToken(err, expr.Paren)
+	}
+
+	if native, ok := function.(*BuildinFun); ok {
+		if err := i.checkCapability(native, expr.Paren); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return function, this, args, nil
+}
+
+// evalCallee evaluates the callee of a call. A method called right away is
+// returned unbound along with its instance, which saves binding it.
+func (i *Interpreter) evalCallee(callee Expr) (interface{}, *LoxInstance, error) {
+	switch expr := callee.(type) {
+	case *ExprGet:
+		if err := i.step(); err != nil {
+			return nil, nil, err
+		}
+		value, err := i.eval(expr.Object)
+		if err != nil {
+			return nil, nil, err
+		}
+		if obj, ok := value.(*LoxInstance); ok {
+			if fn := i.findMethod(expr, obj); fn != nil {
+				return fn, obj, nil
+			}
+		}
+		ret, err := i.property(expr, value)
+		return ret, nil, err
+
+	case *ExprSuper:
+		if err := i.step(); err != nil {
+			return nil, nil, err
+		}
+		return i.superMethod(expr)
+
+	default:
+		ret, err := i.eval(callee)
+		return ret, nil, err
 	}
+}
 
+// call calls function, with this as receiver if function is a method.
+func (i *Interpreter) call(function LoxCallable, this *LoxInstance, args []interface{}) (interface{}, error) {
+	if this != nil {
+		return function.(*LoxFunction).invoke(i, this, args)
+	}
 	return function.Call(i, args)
 }
 
-// bind binds a class method to an instance of the class
-func bind(method *LoxFunction, instance *LoxInstance) bool {
-	// The this variable must be defined in the closure's parent environment
-	// if the method is a valid class method.
-	return method.closure.Set("this", 1, instance)
+// bind binds a class method to an instance of the class.
+func bind(method *LoxFunction, instance *LoxInstance) *LoxFunction {
+	bound := *method
+	bound.this = instance
+	return &bound
+}
+
+// bindStatic binds a static method to the class it is looked up on, which
+// may be a subclass of the class defining it.
+func bindStatic(method *LoxFunction, class *LoxClass) *LoxFunction {
+	bound := *method
+	bound.closure = NewEnvironment(method.closure, 1)
+	bound.closure.Define(class) // this
+	return &bound
+}
+
+// InlineCache remembers the result of the last property lookup of a get or
+// set expression, it is valid as long as the object has the same class. The
+// caches are runtime state, kept by the interpreter along with locals.
+type InlineCache struct {
+	table  *methodTable // method table of the class of the object
+	getter *LoxFunction // getter or setter
+	method *LoxFunction
+}
+
+// lookup returns the cache filled for class.
+func (c *InlineCache) lookup(class *LoxClass, name string, setter bool) *InlineCache {
+	table := class.table()
+	if c.table == table {
+		return c
+	}
+	c.table = table
+	if setter {
+		c.getter, c.method = class.FindSetter(name), nil
+	} else {
+		c.getter, c.method = class.FindGetter(name), class.FindMethod(name)
+	}
+	return c
+}
+
+// cache returns the inline cache of a get or set expression.
+func (i *Interpreter) cache(expr Expr) *InlineCache {
+	cache, ok := i.caches[expr]
+	if !ok {
+		cache = &InlineCache{}
+		i.caches[expr] = cache
+	}
+	return cache
 }
 
 func (i *Interpreter) VisitGet(expr *ExprGet) (interface{}, error) {
@@ -333,24 +737,51 @@ func (i *Interpreter) VisitGet(expr *ExprGet) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	return i.property(expr, value)
+}
+
+// findMethod returns the method a get expression refers to on obj, or nil if
+// it refers to a field or a getter.
+func (i *Interpreter) findMethod(expr *ExprGet, obj *LoxInstance) *LoxFunction {
+	filed := expr.Field.Value().(string)
+	if _, ok := obj.fileds[filed]; ok {
+		return nil
+	}
+	cache := i.cache(expr).lookup(obj.class, filed, false)
+	if cache.getter != nil {
+		return nil
+	}
+	return cache.method
+}
+
+// property returns the property of value a get expression refers to.
+func (i *Interpreter) property(expr *ExprGet, value interface{}) (interface{}, error) {
+	filed := expr.Field.Value().(string)
+
+	// static methods are looked up on the class object itself
+	if cls, ok := value.(*LoxClass); ok {
+		if fn := cls.FindStaticMethod(filed); fn != nil {
+			return bindStatic(fn, cls), nil
+		}
+	}
 
 	obj, ok := value.(*LoxInstance)
 	if !ok {
-		panic(NewLoxError(RuntimeError, expr.Dot, "Only instances have properties."))
+		return nil, NewLoxError(RuntimeError, expr.Dot, "Only instances have properties.")
 	}
 
-	filed := expr.Field.Value().(string)
 	if ret, ok := obj.fileds[filed]; ok {
 		return ret, nil
 	}
-	if fn := obj.class.FindMethod(filed); fn != nil {
-		if !bind(fn, obj) {
-			return nil, i.runtimeError(expr.Dot, "Lox error: cannot bind method and instance")
-		}
-		return fn, nil
+	cache := i.cache(expr).lookup(obj.class, filed, false)
+	if cache.getter != nil {
+		return cache.getter.invoke(i, obj, []interface{}{})
 	}
-	panic(NewLoxError(RuntimeError, expr.Dot,
-		fmt.Sprintf("Undefined property '%s'.", expr.Field.lexeme)))
+	if cache.method != nil {
+		return bind(cache.method, obj), nil
+	}
+	return nil, NewLoxError(RuntimeError, expr.Dot,
+		fmt.Sprintf("Undefined property '%s'.", expr.Field.lexeme))
 }
 
 func (i *Interpreter) VisitSet(expr *ExprSet) (interface{}, error) {
@@ -361,7 +792,7 @@ func (i *Interpreter) VisitSet(expr *ExprSet) (interface{}, error) {
 
 	obj, ok := value.(*LoxInstance)
 	if !ok {
-		panic(NewLoxError(RuntimeError, expr.Dot, "Only instances have fields."))
+		return nil, NewLoxError(RuntimeError, expr.Dot, "Only instances have fields.")
 	}
 
 	ret, err := i.eval(expr.Value)
@@ -369,47 +800,105 @@ func (i *Interpreter) VisitSet(expr *ExprSet) (interface{}, error) {
 		return nil, err
 	}
 	filed := expr.Field.Value().(string)
+	if fn := i.cache(expr).lookup(obj.class, filed, true).getter; fn != nil {
+		if _, err := fn.invoke(i, obj, []interface{}{ret}); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+	if _, ok := obj.fileds[filed]; !ok {
+		if err := i.alloc(MemInstances, fieldSize+len(filed)); err != nil {
+			return nil, atToken(err, expr.Field)
+		}
+	}
 	obj.fileds[filed] = ret
 	return ret, nil
 }
 
 func (i *Interpreter) VisitThis(expr *ExprThis) (interface{}, error) {
-	err := i.runtimeError(expr.Keyword, "Lox error: cannot resolve this")
 	// get in local env
-	if depth, ok := i.locals[expr]; ok {
-		if this, ok := i.localEnv.Get("this", depth); ok {
-			return this, nil
-		}
+	if local, ok := i.locals[expr]; ok {
+		return i.localEnv.Get(local.depth, local.slot), nil
 	}
-	return nil, err
+	return nil, i.runtimeError(expr, expr.Keyword, "Lox error: cannot resolve this")
 }
 
 func (i *Interpreter) VisitSuper(expr *ExprSuper) (interface{}, error) {
-	// get in local env
-	var super, this interface{}
+	fn, this, err := i.superMethod(expr)
+	if err != nil {
+		return nil, err
+	}
+	return bind(fn, this), nil
+}
+
+// superMethod returns the superclass method a super expression refers to and
+// the instance it is called on.
+func (i *Interpreter) superMethod(expr *ExprSuper) (*LoxFunction, *LoxInstance, error) {
+	// get in local env, this is in the frame of the method, which is
+	// enclosed by the environment of super
+	local, ok := i.locals[expr]
+	if !ok {
+		return nil, nil, i.runtimeError(expr, expr.Keyword, "Lox error: cannot resolve super")
+	}
+	super := i.localEnv.Get(local.depth, local.slot)
+	this := i.localEnv.Get(local.depth-1, thisSlot)
+
 	var fn *LoxFunction
-	var ok bool
-	var depth int
+	if fn = super.(*LoxClass).FindMethod(expr.Method.Value().(string)); fn == nil {
+		msg := fmt.Sprintf("Undefined property '%s'.", expr.Method.Value().(string))
+		return nil, nil, NewLoxError(RuntimeError, expr.Method, msg)
+	}
+	return fn, this.(*LoxInstance), nil
+}
 
-	if depth, ok = i.locals[expr]; !ok {
-		return nil, i.runtimeError(expr.Keyword, "Lox error: cannot resolve super")
+func (i *Interpreter) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	value, err := i.eval(expr.Object)
+	if err != nil {
+		return nil, err
 	}
-	if super, ok = i.localEnv.Get("super", depth); !ok {
-		return nil, i.runtimeError(expr.Keyword, "Lox error: cannot resolve super")
+
+	index, err := i.eval(expr.Index)
+	if err != nil {
+		return nil, err
 	}
-	if this, ok = i.localEnv.Get("this", depth); !ok {
-		return nil, i.runtimeError(expr.Keyword, "Lox error: cannot resolve this")
+
+	if list, ok := value.(*LoxList); ok {
+		n, ok := index.(float64)
+		if !ok {
+			return nil, NewLoxError(RuntimeError, expr.Bracket, "List index must be a number.")
+		}
+		element, ok := list.Get(n)
+		if !ok {
+			return nil, NewLoxError(RuntimeError, expr.Bracket, "List index out of range.")
+		}
+		return element, nil
 	}
-	if fn = super.(*LoxClass).FindMethod(expr.Method.Value().(string)); fn == nil {
-		msg := fmt.Sprintf("Undefined property '%s'.", expr.Method.Value().(string))
-		panic(NewLoxError(RuntimeError, expr.Method, msg))
+
+	obj, ok := value.(*LoxInstance)
+	if !ok {
+		return nil, NewLoxError(RuntimeError, expr.Bracket, "Only lists and instances can be indexed.")
 	}
-	bind(fn, this.(*LoxInstance))
-	return fn, nil
+
+	ret, found, err := i.callSpecial(obj, "__index__", expr.Bracket, index)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, NewLoxError(RuntimeError, expr.Bracket,
+			fmt.Sprintf("Undefined operator '[]' for %s.", obj))
+	}
+	return ret, nil
+}
+
+func (i *Interpreter) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	return nil, NewLoxError(RuntimeError, expr.Ellipsis, "Can only spread lists in call arguments.")
 }
 
 func (i *Interpreter) VisitExpression(statement *StmtExpression) (interface{}, error) {
-	return i.eval(statement.Expression)
+	if _, err := i.eval(statement.Expression); err != nil {
+		return nil, err
+	}
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitPrint(statement *StmtPrint) (interface{}, error) {
@@ -417,8 +906,19 @@ func (i *Interpreter) VisitPrint(statement *StmtPrint) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(value)
-	return nil, nil
+
+	if obj, ok := value.(*LoxInstance); ok {
+		str, found, err := i.callSpecial(obj, "__str__", statement.Keyword)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			value = str
+		}
+	}
+
+	fmt.Fprintln(i.stdout, loxString(value))
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitVar(statement *StmtVar) (interface{}, error) {
@@ -435,12 +935,12 @@ func (i *Interpreter) VisitVar(statement *StmtVar) (interface{}, error) {
 		}
 	}
 
-	i.localEnv.Define(name, initializer)
+	i.define(name, initializer)
 
-	return nil, nil
+	return NormalCompletion, nil
 }
 
-func (i *Interpreter) execBlock(statements []Stmt, env *Environment) (interface{}, error) {
+func (i *Interpreter) execBlock(statements []Stmt, env *Environment) (Completion, error) {
 	// enter new environment
 	previous := i.localEnv
 	i.localEnv = env
@@ -451,16 +951,21 @@ func (i *Interpreter) execBlock(statements []Stmt, env *Environment) (interface{
 	}()
 
 	for _, statement := range statements {
-		if err := i.execute(statement); err != nil {
-			return nil, err
+		c, err := i.execute(statement)
+		if err != nil || c != NormalCompletion {
+			return c, err
 		}
 	}
 
-	return nil, nil
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitBlock(statement *StmtBlock) (interface{}, error) {
-	return i.execBlock(statement.Statements, NewEnvironment(i.localEnv))
+	size := i.sizes[statement]
+	if err := i.alloc(MemEnvironments, environmentSize+size*valueSize); err != nil {
+		return nil, err
+	}
+	return i.execBlock(statement.Statements, NewEnvironment(i.localEnv, size))
 }
 
 func (i *Interpreter) VisitIf(statement *StmtIf) (interface{}, error) {
@@ -468,67 +973,70 @@ func (i *Interpreter) VisitIf(statement *StmtIf) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if i.branches != nil {
+		i.branches.Branch(statement, isTruthy(cond))
+	}
 	if isTruthy(cond) {
-		return nil, i.execute(statement.Then)
+		return i.execute(statement.Then)
 	} else if statement.Else != nil {
-		return nil, i.execute(statement.Else)
+		return i.execute(statement.Else)
 	}
-	return nil, nil
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitWhile(statement *StmtWhile) (interface{}, error) {
 	for {
+		if err := i.checkContext(); err != nil {
+			return nil, atToken(err, statement.Keyword)
+		}
 		cond, err := i.eval(statement.Cond)
 		if err != nil {
-			return nil, err
+			return nil, atToken(err, statement.Keyword)
+		}
+		if i.branches != nil {
+			i.branches.Branch(statement, isTruthy(cond))
 		}
 		if !isTruthy(cond) {
 			break
 		}
-		err = i.execute(statement.Body)
+		c, err := i.execute(statement.Body)
 		if err != nil {
-			return nil, err
+			return nil, atToken(err, statement.Keyword)
+		}
+		if c != NormalCompletion {
+			return c, nil
 		}
 	}
-	return nil, nil
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitFun(statement *StmtFun) (interface{}, error) {
+	if err := i.alloc(MemClosures, closureSize); err != nil {
+		return nil, err
+	}
 	fn := NewLoxFunction(statement, i.localEnv, false)
-	i.localEnv.Define(statement.Name, fn)
-	return fn, nil
-}
-
-// Return implements the error interface, and throwed in VisitReturn.
-// Should be caught at LoxFunction.Call
-type Return struct {
-	HasVal bool
-	Value  interface{}
-}
-
-func (r *Return) Error() string {
-	return ""
+	i.define(statement.Name.lexeme, fn)
+	return NormalCompletion, nil
 }
 
 func (i *Interpreter) VisitReturn(statement *StmtReturn) (interface{}, error) {
+	i.retval = nil
+	if i.tails[statement] {
+		return i.tailReturn(statement.Value.(*ExprCall))
+	}
 	if statement.Value != nil {
 		value, err := i.eval(statement.Value)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		panic(&Return{true, value})
+		i.retval = value
 	}
-	panic(&Return{false, nil})
+	return ReturnCompletion, nil
 }
 
 func (i *Interpreter) defineMethod(class *LoxClass, statement *StmtFun) {
-	var fn *LoxFunction
-	if statement.Name == "init" {
-		fn = NewLoxFunction(statement, i.localEnv, true /* isInitializer */)
-	} else {
-		fn = NewLoxFunction(statement, i.localEnv, false)
-	}
-	class.DefineMethod(statement.Name, fn)
+	isInitializer := statement.Name.lexeme == "init"
+	class.DefineMethod(statement.Name.lexeme, NewLoxMethod(statement, i.localEnv, isInitializer))
 }
 
 func (i *Interpreter) VisitClass(statement *StmtClass) (interface{}, error) {
@@ -537,31 +1045,92 @@ func (i *Interpreter) VisitClass(statement *StmtClass) (interface{}, error) {
 	if statement.Superclass == nil {
 		cls = NewLoxClass(statement, nil)
 	} else {
-		superclass, err := i.VisitVariable(statement.Superclass)
+		value, err := i.VisitVariable(statement.Superclass)
 		if err != nil {
 			return nil, err
 		}
-		cls = NewLoxClass(statement, superclass.(*LoxClass))
+		superclass, ok := value.(*LoxClass)
+		if !ok {
+			return nil, NewLoxError(RuntimeError, statement.Superclass.Name, "Superclass must be a class.")
+		}
+		cls = NewLoxClass(statement, superclass)
 	}
 
-	i.localEnv.Define(statement.Name, cls)
+	i.define(statement.Name.lexeme, cls)
 
-	// define a new environment to store pointer this and super
+	if err := i.applyTraits(cls, statement); err != nil {
+		return nil, err
+	}
+
+	// define a new environment to store super
 	preEnv := i.localEnv
-	i.localEnv = NewEnvironment(i.localEnv)
-	i.localEnv.Define("this", nil)
 	if statement.Superclass != nil {
-		i.localEnv.Define("super", cls.superclass)
+		i.localEnv = NewEnvironment(i.localEnv, 1)
+		i.localEnv.Define(cls.superclass)
 	}
 
-	// yet another environment to store class methods
-	// TODO: remove this new env create
-	i.localEnv = NewEnvironment(i.localEnv)
 	for _, method := range statement.Methods {
 		i.defineMethod(cls, method)
 	}
+	for _, getter := range statement.Getters {
+		cls.DefineGetter(getter.Name.lexeme, NewLoxMethod(getter, i.localEnv, false))
+	}
+	for _, setter := range statement.Setters {
+		cls.DefineSetter(setter.Name.lexeme, NewLoxMethod(setter, i.localEnv, false))
+	}
 
 	// quit to origin env
 	i.localEnv = preEnv
-	return cls, nil
+
+	// static methods have no super, this is bound by bindStatic
+	for _, method := range statement.StaticMethods {
+		cls.DefineStaticMethod(method.Name.lexeme, NewLoxFunction(method, i.localEnv, false))
+	}
+	return NormalCompletion, nil
+}
+
+// applyTraits copies the methods of the traits used by a class into its
+// method table. A method provided by more than one trait is a conflict unless
+// the class defines the method itself.
+func (i *Interpreter) applyTraits(cls *LoxClass, statement *StmtClass) error {
+	own := make(map[string]bool)
+	for _, method := range statement.Methods {
+		own[method.Name.lexeme] = true
+	}
+
+	providers := make(map[string]*LoxTrait)
+	for _, expr := range statement.Traits {
+		value, err := i.VisitVariable(expr)
+		if err != nil {
+			return err
+		}
+		trait, ok := value.(*LoxTrait)
+		if !ok {
+			return NewLoxError(RuntimeError, expr.Name, "Can only use traits in 'with' clause.")
+		}
+		for _, name := range trait.MethodNames() {
+			if own[name] {
+				continue
+			}
+			if prev, ok := providers[name]; ok {
+				return NewLoxError(RuntimeError, expr.Name,
+					fmt.Sprintf("Method '%s' is provided by both traits %s and %s.", name, prev, trait))
+			}
+			providers[name] = trait
+			cls.DefineMethod(name, trait.methods[name])
+		}
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitTrait(statement *StmtTrait) (interface{}, error) {
+	trait := NewLoxTrait(statement)
+	i.define(statement.Name.lexeme, trait)
+
+	// trait methods are bound to instances the same way as class methods
+	for _, method := range statement.Methods {
+		isInitializer := method.Name.lexeme == "init"
+		trait.DefineMethod(method.Name.lexeme, NewLoxMethod(method, i.localEnv, isInitializer))
+	}
+	return NormalCompletion, nil
 }
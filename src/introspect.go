@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// typeName returns the name of the runtime type of value.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *LoxFunction, *BuildinFun:
+		return "function"
+	case *LoxClass:
+		return "class"
+	case *LoxInstance:
+		return "instance"
+	case *LoxTrait:
+		return "trait"
+	case *LoxList:
+		return "list"
+	default:
+		return "unknown"
+	}
+}
+
+// type
+var BuildinType *BuildinFun = &BuildinFun{
+	name:  "type",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		return typeName(args[0]), nil
+	},
+}
+
+// instanceOf
+var BuildinInstanceOf *BuildinFun = &BuildinFun{
+	name:  "instanceOf",
+	arity: 2,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		cls, ok := args[1].(*LoxClass)
+		if !ok {
+			return nil, errors.New("instanceOf only accept class as second arg")
+		}
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return false, nil
+		}
+		for c := obj.class; c != nil; c = c.superclass {
+			if c == cls {
+				return true, nil
+			}
+		}
+		return false, nil
+	},
+}
+
+// classOf
+var BuildinClassOf *BuildinFun = &BuildinFun{
+	name:  "classOf",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return nil, nil
+		}
+		return obj.class, nil
+	},
+}
+
+// className
+var BuildinClassName *BuildinFun = &BuildinFun{
+	name:  "className",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		cls, ok := args[0].(*LoxClass)
+		if !ok {
+			return nil, errors.New("className only accept class as arg")
+		}
+		return cls.name, nil
+	},
+}
+
+// superclassOf
+var BuildinSuperclassOf *BuildinFun = &BuildinFun{
+	name:  "superclassOf",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		cls, ok := args[0].(*LoxClass)
+		if !ok {
+			return nil, errors.New("superclassOf only accept class as arg")
+		}
+		if cls.superclass == nil {
+			return nil, nil
+		}
+		return cls.superclass, nil
+	},
+}
+
+// sortedList builds a list from a set of names in sorted order.
+func sortedList(i *Interpreter, names map[string]bool) (*LoxList, error) {
+	if err := i.alloc(MemCollections, listSize+len(names)*valueSize); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	elements := make([]interface{}, len(keys))
+	for n, key := range keys {
+		elements[n] = key
+	}
+	return NewLoxList(elements), nil
+}
+
+// fields
+var BuildinFields *BuildinFun = &BuildinFun{
+	name:  "fields",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return nil, errors.New("fields only accept instance as arg")
+		}
+		names := make(map[string]bool)
+		for name := range obj.fileds {
+			names[name] = true
+		}
+		return sortedList(i, names)
+	},
+}
+
+// methods returns the names of the methods of a class and its superclasses,
+// static methods, getters and setters included.
+var BuildinMethods *BuildinFun = &BuildinFun{
+	name:  "methods",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		cls, ok := args[0].(*LoxClass)
+		if !ok {
+			return nil, errors.New("methods only accept class as arg")
+		}
+		table := cls.table()
+		names := make(map[string]bool)
+		for _, methods := range []map[string]*LoxFunction{
+			table.methods, table.staticMethods, table.getters, table.setters,
+		} {
+			for name := range methods {
+				names[name] = true
+			}
+		}
+		return sortedList(i, names)
+	},
+}
+
+// hasField
+var BuildinHasField *BuildinFun = &BuildinFun{
+	name:  "hasField",
+	arity: 2,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return false, nil
+		}
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("hasField only accept string as field name")
+		}
+		_, found := obj.fileds[name]
+		return found, nil
+	},
+}
+
+// getField
+var BuildinGetField *BuildinFun = &BuildinFun{
+	name:  "getField",
+	arity: 2,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return nil, errors.New("getField only accept instance as first arg")
+		}
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("getField only accept string as field name")
+		}
+		value, found := obj.fileds[name]
+		if !found {
+			return nil, errors.New("Undefined property '" + name + "'.")
+		}
+		return value, nil
+	},
+}
+
+// setField
+var BuildinSetField *BuildinFun = &BuildinFun{
+	name:  "setField",
+	arity: 3,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(*LoxInstance)
+		if !ok {
+			return nil, errors.New("setField only accept instance as first arg")
+		}
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("setField only accept string as field name")
+		}
+		if _, ok := obj.fileds[name]; !ok {
+			if err := i.alloc(MemInstances, fieldSize+len(name)); err != nil {
+				return nil, err
+			}
+		}
+		obj.fileds[name] = args[2]
+		return args[2], nil
+	},
+}
+
+// arity returns the minimum and maximum numbers of arguments as a list, the
+// maximum being -1 if any number of extra arguments is accepted
+var BuildinArity *BuildinFun = &BuildinFun{
+	name:  "arity",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		fn, ok := args[0].(LoxCallable)
+		// instances are callable through their __call__ method
+		if obj, isInstance := args[0].(*LoxInstance); isInstance {
+			if method := obj.class.FindMethod("__call__"); method != nil {
+				fn, ok = method, true
+			}
+		}
+		if !ok {
+			return nil, errors.New("arity only accept function, class or callable instance as arg")
+		}
+		if err := i.alloc(MemCollections, listSize+2*valueSize); err != nil {
+			return nil, err
+		}
+		min, max := fn.Arity()
+		return NewLoxList([]interface{}{float64(min), float64(max)}), nil
+	},
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultMaxCallDepth keeps deep recursion well below the size at which the
+// Go stack of the interpreter overflows.
+const DefaultMaxCallDepth = 10000
+
+// Limits bounds the resources used by a program, a zero value means no limit.
+type Limits struct {
+	MaxCallDepth int           // maximum depth of nested calls
+	MaxSteps     int           // maximum number of executed statements and expressions
+	Timeout      time.Duration // maximum wall-clock time of one Interprete
+	AllocBudget  int64         // maximum total bytes allocated, see Interpreter.alloc
+}
+
+var DefaultLimits = Limits{
+	MaxCallDepth: DefaultMaxCallDepth,
+}
+
+// Errors reported when a limit is exceeded. They are turned into runtime
+// errors at the innermost call or loop, see atToken, or else at the
+// statement of the script, see atStatement.
+var (
+	errStackOverflow = errors.New("Stack overflow.")
+	errStepLimit     = errors.New("Step limit exceeded.")
+	errTimeout       = errors.New("Execution timed out.")
+	errCancelled     = errors.New("Execution cancelled.")
+)
+
+func isLimitError(err error) bool {
+	return err == errStackOverflow || err == errStepLimit ||
+		err == errTimeout || err == errCancelled || err == errOutOfMemory
+}
+
+// atToken reports a limit error at the position of token.
+func atToken(err error, token Token) error {
+	if isLimitError(err) {
+		return NewLoxError(RuntimeError, token, err.Error())
+	}
+	return err
+}
+
+// atStatement reports a limit error at statement, for the errors raised out
+// of any call or loop.
+func atStatement(err error, statement Stmt) error {
+	if token, ok := nodeToken(statement); ok && isLimitError(err) {
+		return NewLoxError(RuntimeError, token, err.Error()).spanning(statement.Span())
+	}
+	return err
+}
+
+func (i *Interpreter) SetLimits(limits Limits) {
+	i.limits = limits
+}
+
+// SetContext sets the context whose cancellation stops the execution.
+func (i *Interpreter) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
+// step counts an executed statement or expression against the step budget.
+func (i *Interpreter) step() error {
+	if i.limits.MaxSteps <= 0 {
+		return nil
+	}
+	i.steps++
+	if i.steps > i.limits.MaxSteps {
+		return errStepLimit
+	}
+	return nil
+}
+
+// checkContext returns an error if the execution was cancelled or timed out.
+func (i *Interpreter) checkContext() error {
+	select {
+	case <-i.runCtx.Done():
+		if i.runCtx.Err() == context.DeadlineExceeded {
+			return errTimeout
+		}
+		return errCancelled
+	default:
+		return nil
+	}
+}
+
+// enterCall accounts for a new call frame, it must be paired with leaveCall.
+func (i *Interpreter) enterCall() error {
+	i.depth++
+	if i.limits.MaxCallDepth > 0 && i.depth > i.limits.MaxCallDepth {
+		i.depth--
+		return errStackOverflow
+	}
+	return nil
+}
+
+func (i *Interpreter) leaveCall() {
+	i.depth--
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// LintRule is a check of the linter, enabled and disabled by name.
+type LintRule struct {
+	Name string
+	Doc  string
+}
+
+var lintRules = []LintRule{
+	{"unused-variable", "local variable, function or class which is never read"},
+	{"unused-parameter", "parameter which is never read"},
+	{"shadow", "declaration hiding a variable of an enclosing scope"},
+	{"unreachable", "statement following a return"},
+	{"undeclared-assign", "assignment to a variable which is declared nowhere"},
+	{"arity", "call with a number of arguments the function does not accept"},
+	{"this-in-function", "this used in a function out of any class"},
+	{"empty-block", "block without statements or comments"},
+	{"self-compare", "comparison of an expression with itself"},
+}
+
+// Finding is a problem reported by the linter. The errors of a program are
+// reported as findings of the rule "error", which cannot be disabled.
+type Finding struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Rule      string `json:"rule"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+// Linter reports suspicious constructs of programs. Rules about variables
+// use the declarations and uses found by the resolver, the others walk the
+// syntax tree.
+type Linter struct {
+	rules   map[string]bool
+	natives map[string]LoxCallable
+
+	// state of the program being linted
+	a        *Analysis
+	findings []Finding
+	decls    map[int]*Declaration // declarations of the used names, by offset
+	assigned map[*Declaration]bool
+	globals  map[string]int // number of declarations of each global
+	funcs    []functionType // enclosing functions
+}
+
+var (
+	_ ExprVisitor = &Linter{}
+	_ StmtVisitor = &Linter{}
+)
+
+// NewLinter returns a linter applying the given rules, or all of them if
+// rules is nil.
+func NewLinter(rules map[string]bool) *Linter {
+	if rules == nil {
+		rules = make(map[string]bool)
+		for _, rule := range lintRules {
+			rules[rule.Name] = true
+		}
+	}
+	natives := make(map[string]LoxCallable)
+	for name, value := range NewInterpreter().globals {
+		if fn, ok := value.(LoxCallable); ok {
+			natives[name] = fn
+		}
+	}
+	return &Linter{rules: rules, natives: natives}
+}
+
+// Lint returns the findings of src, ordered by position.
+func (l *Linter) Lint(src string) []Finding {
+	l.a = Analyze(src)
+	l.findings = make([]Finding, 0)
+	l.decls = make(map[int]*Declaration)
+	l.assigned = make(map[*Declaration]bool)
+	l.globals = make(map[string]int)
+	l.funcs = l.funcs[:0]
+
+	for _, problem := range l.a.Problems {
+		line, col := l.a.Position(problem.Offset)
+		endLine, endCol := l.a.Position(problem.Offset + problem.Length)
+		l.findings = append(l.findings, Finding{
+			Line: line, Column: col, EndLine: endLine, EndColumn: endCol,
+			Rule: "error", Severity: "error", Message: problem.Msg,
+		})
+	}
+
+	l.checkVariables()
+	l.checkEmptyBlocks()
+	l.stmts(l.a.Statements)
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		if l.findings[i].Line != l.findings[j].Line {
+			return l.findings[i].Line < l.findings[j].Line
+		}
+		return l.findings[i].Column < l.findings[j].Column
+	})
+	return l.findings
+}
+
+// report reports a finding of rule about the source of span.
+func (l *Linter) report(rule string, span Span, format string, args ...interface{}) {
+	if !l.rules[rule] {
+		return
+	}
+	line, col := l.a.Position(span.Start.Offset)
+	endLine, endCol := l.a.Position(span.End.Offset)
+	l.findings = append(l.findings, Finding{
+		Line: line, Column: col, EndLine: endLine, EndColumn: endCol,
+		Rule: rule, Severity: "warning", Message: fmt.Sprintf(format, args...),
+	})
+}
+
+var declKindNames = map[DeclKind]string{
+	VarDecl:   "variable",
+	FunDecl:   "function",
+	ClassDecl: "class",
+	TraitDecl: "trait",
+	ParamDecl: "parameter",
+}
+
+func (l *Linter) checkVariables() {
+	read := make(map[*Declaration]bool)
+	for _, use := range l.a.Uses {
+		l.decls[use.Name.offset] = use.Decl
+		switch {
+		case use.Decl == nil:
+			if _, native := l.natives[use.Name.lexeme]; use.Assign && !native {
+				l.report("undeclared-assign", tokenSpan(use.Name), "Assignment to undeclared variable '%s'.", use.Name.lexeme)
+			}
+		case use.Assign:
+			l.assigned[use.Decl] = true
+		default:
+			read[use.Decl] = true
+		}
+	}
+
+	for _, decl := range l.a.Decls {
+		if decl.Kind == MethodDecl {
+			continue
+		}
+		name := decl.Name.lexeme
+		if decl.Depth == 0 {
+			l.globals[name]++
+			continue
+		}
+
+		if !read[decl] && !strings.HasPrefix(name, "_") {
+			if decl.Kind == ParamDecl {
+				l.report("unused-parameter", tokenSpan(decl.Name), "Parameter '%s' is never used.", name)
+			} else {
+				l.report("unused-variable", tokenSpan(decl.Name), "Local %s '%s' is never used.", declKindNames[decl.Kind], name)
+			}
+		}
+
+		shadowed := decl.Shadows
+		if global := l.a.globals[name]; shadowed == nil && global != nil && global.Name.offset < decl.Name.offset {
+			shadowed = global
+		}
+		if shadowed != nil {
+			line, _ := l.a.Position(shadowed.Name.offset)
+			l.report("shadow", tokenSpan(decl.Name), "Declaration of '%s' shadows the %s declared at line %d.",
+				name, declKindNames[shadowed.Kind], line)
+		}
+	}
+}
+
+// checkEmptyBlocks reports the blocks of statements with nothing between
+// their braces. Empty function and class bodies are not reported.
+func (l *Linter) checkEmptyBlocks() {
+	tokens := l.a.Tokens
+	for n := 0; n+1 < len(tokens); n++ {
+		if tokens[n].typ != LEFT_BRACE || tokens[n+1].typ != RIGHT_BRACE {
+			continue
+		}
+		if l.hasComment(tokens[n].offset, tokens[n+1].offset) || !l.isStatementBlock(n) {
+			continue
+		}
+		l.report("empty-block", Span{Start: tokens[n].start(), End: tokens[n+1].end()}, "Empty block.")
+	}
+}
+
+func (l *Linter) hasComment(start, end int) bool {
+	for _, comment := range l.a.Comments {
+		if comment.Offset > start && comment.Offset < end {
+			return true
+		}
+	}
+	return false
+}
+
+// isStatementBlock tells if the brace at index n of the tokens opens a block
+// statement, or the body of a control flow statement.
+func (l *Linter) isStatementBlock(n int) bool {
+	tokens := l.a.Tokens
+	if n == 0 {
+		return true
+	}
+	switch tokens[n-1].typ {
+	case ELSE, LEFT_BRACE, RIGHT_BRACE, SEMICOLON:
+		return true
+	case RIGHT_PAREN:
+		depth := 0
+		for k := n - 1; k >= 0; k-- {
+			switch tokens[k].typ {
+			case RIGHT_PAREN:
+				depth++
+			case LEFT_PAREN:
+				depth--
+			}
+			if depth == 0 {
+				if k == 0 {
+					return false
+				}
+				typ := tokens[k-1].typ
+				return typ == IF || typ == WHILE || typ == FOR
+			}
+		}
+	}
+	return false
+}
+
+// nodeToken returns the first token held by a node, which locates the node
+// in the source.
+func nodeToken(node interface{}) (Token, bool) {
+	switch n := node.(type) {
+	case *ExprVariable:
+		return n.Name, true
+	case *ExprAssign:
+		return n.Name, true
+	case *ExprUnary:
+		return n.UnaryOperator, true
+	case *ExprGrouping:
+		return nodeToken(n.Expression)
+	case *ExprBinary:
+		if token, ok := nodeToken(n.Left); ok {
+			return token, true
+		}
+		return n.Operator, true
+	case *ExprLogical:
+		if token, ok := nodeToken(n.Left); ok {
+			return token, true
+		}
+		return n.Operator, true
+	case *ExprCall:
+		if token, ok := nodeToken(n.Callee); ok {
+			return token, true
+		}
+		return n.Paren, true
+	case *ExprGet:
+		if token, ok := nodeToken(n.Object); ok {
+			return token, true
+		}
+		return n.Dot, true
+	case *ExprSet:
+		if token, ok := nodeToken(n.Object); ok {
+			return token, true
+		}
+		return n.Dot, true
+	case *ExprThis:
+		return n.Keyword, true
+	case *ExprSuper:
+		return n.Keyword, true
+	case *ExprIndex:
+		if token, ok := nodeToken(n.Object); ok {
+			return token, true
+		}
+		return n.Bracket, true
+	case *ExprSpread:
+		return n.Ellipsis, true
+	case *StmtExpression:
+		return nodeToken(n.Expression)
+	case *StmtPrint:
+		return n.Keyword, true
+	case *StmtVar:
+		return n.Name, true
+	case *StmtBlock:
+		if len(n.Statements) > 0 {
+			return nodeToken(n.Statements[0])
+		}
+	case *StmtIf:
+		return nodeToken(n.Cond)
+	case *StmtWhile:
+		return n.Keyword, true
+	case *StmtFun:
+		return n.Name, true
+	case *StmtReturn:
+		return n.Keyword, true
+	case *StmtClass:
+		return n.Name, true
+	case *StmtTrait:
+		return n.Name, true
+	}
+	return Token{}, false
+}
+
+// sameExpr tells if two expressions certainly denote the same value.
+func sameExpr(a, b Expr) bool {
+	switch x := a.(type) {
+	case *ExprVariable:
+		y, ok := b.(*ExprVariable)
+		return ok && x.Name.lexeme == y.Name.lexeme
+	case *ExprThis:
+		_, ok := b.(*ExprThis)
+		return ok
+	case *ExprGet:
+		y, ok := b.(*ExprGet)
+		return ok && x.Field.lexeme == y.Field.lexeme && sameExpr(x.Object, y.Object)
+	case *ExprGrouping:
+		y, ok := b.(*ExprGrouping)
+		return ok && sameExpr(x.Expression, y.Expression)
+	}
+	return false
+}
+
+// arity returns the arity of the function called by callee, if it is known
+// statically.
+func (l *Linter) arity(callee Expr) (name string, min, max int, ok bool) {
+	variable, isVariable := callee.(*ExprVariable)
+	if !isVariable {
+		return "", 0, 0, false
+	}
+	name = variable.Name.lexeme
+	decl := l.decls[variable.Name.offset]
+	if decl == nil {
+		if fn, native := l.natives[name]; native && l.globals[name] == 0 {
+			min, max = fn.Arity()
+			return name, min, max, true
+		}
+		return "", 0, 0, false
+	}
+	// the variable may refer to another function
+	if l.assigned[decl] || (decl.Depth == 0 && l.globals[name] > 1) {
+		return "", 0, 0, false
+	}
+
+	switch node := decl.Node.(type) {
+	case *StmtFun:
+		if decl.Kind == FunDecl {
+			min, max = declArity(node)
+			return name, min, max, true
+		}
+	case *StmtClass:
+		for _, method := range node.Methods {
+			if method.Name.lexeme == "init" {
+				min, max = declArity(method)
+				return name, min, max, true
+			}
+		}
+		if node.Superclass == nil && len(node.Traits) == 0 {
+			return name, 0, 0, true
+		}
+	}
+	return "", 0, 0, false
+}
+
+func (l *Linter) inFunction(t functionType) bool {
+	for _, fn := range l.funcs {
+		if fn == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isProblem tells if an error of the program is reported at token.
+func (l *Linter) isProblem(token Token) bool {
+	for _, problem := range l.a.Problems {
+		if problem.Offset == token.offset {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Linter) expr(expr Expr) {
+	if expr != nil {
+		expr.Accept(l)
+	}
+}
+
+func (l *Linter) stmt(stmt Stmt) {
+	if stmt != nil {
+		stmt.Accept(l)
+	}
+}
+
+func (l *Linter) stmts(statements []Stmt) {
+	unreachable := false
+	for n, statement := range statements {
+		l.stmt(statement)
+		if ret, ok := statement.(*StmtReturn); ok && n+1 < len(statements) && !unreachable {
+			span := statements[n+1].Span()
+			if span.IsZero() {
+				span = ret.Span()
+			}
+			l.report("unreachable", span, "Unreachable code after return.")
+			unreachable = true
+		}
+	}
+}
+
+func (l *Linter) function(fun *StmtFun, t functionType) {
+	l.funcs = append(l.funcs, t)
+	for _, value := range fun.Defaults {
+		l.expr(value)
+	}
+	l.stmts(fun.Body)
+	l.funcs = l.funcs[:len(l.funcs)-1]
+}
+
+func (l *Linter) VisitLiteral(expr *ExprLiteral) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *Linter) VisitVariable(expr *ExprVariable) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *Linter) VisitAssign(expr *ExprAssign) (interface{}, error) {
+	l.expr(expr.Value)
+	return nil, nil
+}
+
+func (l *Linter) VisitUnary(expr *ExprUnary) (interface{}, error) {
+	l.expr(expr.Expression)
+	return nil, nil
+}
+
+func (l *Linter) VisitGrouping(expr *ExprGrouping) (interface{}, error) {
+	l.expr(expr.Expression)
+	return nil, nil
+}
+
+func (l *Linter) VisitBinary(expr *ExprBinary) (interface{}, error) {
+	l.expr(expr.Left)
+	l.expr(expr.Right)
+	switch expr.Operator.typ {
+	case EQUAL_EQUAL, BANG_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+		if sameExpr(expr.Left, expr.Right) {
+			l.report("self-compare", expr.Span(), "Comparison of an expression with itself.")
+		}
+	}
+	return nil, nil
+}
+
+func (l *Linter) VisitLogical(expr *ExprLogical) (interface{}, error) {
+	l.expr(expr.Left)
+	l.expr(expr.Right)
+	return nil, nil
+}
+
+func (l *Linter) VisitCall(expr *ExprCall) (interface{}, error) {
+	l.expr(expr.Callee)
+	spread := false
+	for _, arg := range expr.Args {
+		l.expr(arg)
+		if _, ok := arg.(*ExprSpread); ok {
+			spread = true
+		}
+	}
+	if name, min, max, ok := l.arity(expr.Callee); ok && !spread && !checkArity(min, max, len(expr.Args)) {
+		l.report("arity", expr.Span(), "Call to '%s': %s", name, arityError(min, max, len(expr.Args)))
+	}
+	return nil, nil
+}
+
+func (l *Linter) VisitGet(expr *ExprGet) (interface{}, error) {
+	l.expr(expr.Object)
+	return nil, nil
+}
+
+func (l *Linter) VisitSet(expr *ExprSet) (interface{}, error) {
+	l.expr(expr.Object)
+	l.expr(expr.Value)
+	return nil, nil
+}
+
+func (l *Linter) VisitThis(expr *ExprThis) (interface{}, error) {
+	// functions nested in methods see the this of the method. Out of any
+	// class, the resolver stops at the first this, which is not reported
+	// twice.
+	inClass := l.inFunction(Method) || l.inFunction(Initializer) || l.inFunction(StaticMethod)
+	if len(l.funcs) > 0 && !inClass && !l.isProblem(expr.Keyword) {
+		l.report("this-in-function", tokenSpan(expr.Keyword), "'this' used in a function out of any class.")
+	}
+	return nil, nil
+}
+
+func (l *Linter) VisitSuper(expr *ExprSuper) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *Linter) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	l.expr(expr.Object)
+	l.expr(expr.Index)
+	return nil, nil
+}
+
+func (l *Linter) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	l.expr(expr.Expression)
+	return nil, nil
+}
+
+func (l *Linter) VisitExpression(stmt *StmtExpression) (interface{}, error) {
+	l.expr(stmt.Expression)
+	return nil, nil
+}
+
+func (l *Linter) VisitPrint(stmt *StmtPrint) (interface{}, error) {
+	l.expr(stmt.Expression)
+	return nil, nil
+}
+
+func (l *Linter) VisitVar(stmt *StmtVar) (interface{}, error) {
+	l.expr(stmt.Initializer)
+	return nil, nil
+}
+
+func (l *Linter) VisitBlock(stmt *StmtBlock) (interface{}, error) {
+	l.stmts(stmt.Statements)
+	return nil, nil
+}
+
+func (l *Linter) VisitIf(stmt *StmtIf) (interface{}, error) {
+	l.expr(stmt.Cond)
+	l.stmt(stmt.Then)
+	l.stmt(stmt.Else)
+	return nil, nil
+}
+
+func (l *Linter) VisitWhile(stmt *StmtWhile) (interface{}, error) {
+	l.expr(stmt.Cond)
+	l.stmt(stmt.Body)
+	return nil, nil
+}
+
+func (l *Linter) VisitFun(stmt *StmtFun) (interface{}, error) {
+	l.function(stmt, NormalFunc)
+	return nil, nil
+}
+
+func (l *Linter) VisitReturn(stmt *StmtReturn) (interface{}, error) {
+	l.expr(stmt.Value)
+	return nil, nil
+}
+
+func (l *Linter) VisitClass(stmt *StmtClass) (interface{}, error) {
+	for _, method := range stmt.Methods {
+		if method.Name.lexeme == "init" {
+			l.function(method, Initializer)
+		} else {
+			l.function(method, Method)
+		}
+	}
+	for _, getter := range stmt.Getters {
+		l.function(getter, Method)
+	}
+	for _, setter := range stmt.Setters {
+		l.function(setter, Method)
+	}
+	for _, method := range stmt.StaticMethods {
+		l.function(method, StaticMethod)
+	}
+	return nil, nil
+}
+
+func (l *Linter) VisitTrait(stmt *StmtTrait) (interface{}, error) {
+	for _, method := range stmt.Methods {
+		l.function(method, Method)
+	}
+	return nil, nil
+}
+
+// lintCommand reports the findings of the linter on Lox files, or on the
+// standard input.
+func lintCommand(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the findings as a JSON array")
+	enable := flags.String("enable", "", "comma separated rules to apply, instead of all")
+	disable := flags.String("disable", "", "comma separated rules not to apply")
+	list := flags.Bool("rules", false, "list the rules and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s lint [flags] [files or directories...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *list {
+		for _, rule := range lintRules {
+			fmt.Printf("%-18s %s\n", rule.Name, rule.Doc)
+		}
+		return nil
+	}
+
+	rules, err := lintRuleSet(*enable, *disable)
+	if err != nil {
+		return err
+	}
+	linter := NewLinter(rules)
+
+	findings := make([]Finding, 0)
+	lint := func(filename string, data []byte) {
+		for _, finding := range linter.Lint(string(data)) {
+			finding.File = filename
+			findings = append(findings, finding)
+		}
+	}
+	if flags.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		lint("<stdin>", data)
+	} else {
+		filenames, err := loxFiles(flags.Args())
+		if err != nil {
+			return err
+		}
+		for _, filename := range filenames {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			lint(filename, data)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Printf("%s:%d:%d: %s: %s (%s)\n", f.File, f.Line, f.Column, f.Severity, f.Message, f.Rule)
+		}
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("lint: %d problems", len(findings))
+	}
+	return nil
+}
+
+// lintRuleSet returns the rules enabled by the -enable and -disable flags.
+func lintRuleSet(enable, disable string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, rule := range lintRules {
+		known[rule.Name] = true
+	}
+	names := func(list string) ([]string, error) {
+		result := make([]string, 0)
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !known[name] {
+				return nil, fmt.Errorf("lint: unknown rule %q", name)
+			}
+			result = append(result, name)
+		}
+		return result, nil
+	}
+
+	rules := make(map[string]bool)
+	enabled, err := names(enable)
+	if err != nil {
+		return nil, err
+	}
+	if len(enabled) == 0 {
+		rules = known
+	}
+	for _, name := range enabled {
+		rules[name] = true
+	}
+	disabled, err := names(disable)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range disabled {
+		delete(rules, name)
+	}
+	return rules, nil
+}
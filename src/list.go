@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// LoxList is an ordered collection of values, indexed from zero.
+type LoxList struct {
+	elements []interface{}
+}
+
+func NewLoxList(elements []interface{}) *LoxList {
+	return &LoxList{elements: elements}
+}
+
+func (l *LoxList) Len() int {
+	return len(l.elements)
+}
+
+// Get returns the element at index, ok is false if index is not an integer
+// in range.
+func (l *LoxList) Get(index float64) (value interface{}, ok bool) {
+	n := int(index)
+	if float64(n) != index || n < 0 || n >= len(l.elements) {
+		return nil, false
+	}
+	return l.elements[n], true
+}
+
+func (l *LoxList) String() string {
+	strs := make([]string, len(l.elements))
+	for i, element := range l.elements {
+		strs[i] = loxString(element)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+// len
+var BuildinLen *BuildinFun = &BuildinFun{
+	name:  "len",
+	arity: 1,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		switch value := args[0].(type) {
+		case string:
+			return float64(len(value)), nil
+		case *LoxList:
+			return float64(value.Len()), nil
+		}
+		return nil, errors.New("len only accept string or list as arg")
+	},
+}
@@ -18,22 +18,64 @@ type Logger struct {
 
 var logger = &Logger{}
 
+// Reset sets the source errors are shown in, an empty source for none.
 func (l *Logger) Reset(src string, dwriter, ewriter io.Writer) {
 	l.lines = []string{""}
-	l.lines = append(l.lines, strings.Split(src, "\n")...)
+	if src != "" {
+		l.lines = append(l.lines, strings.Split(src, "\n")...)
+	}
 	l.dwriter = dwriter
 	l.ewriter = ewriter
 }
 
 func (l *Logger) NewError(row, col int, errmsg string) error {
+	return l.NewSpanError(row, col, Span{}, errmsg)
+}
+
+// NewSpanError returns an error pointing at col of row, which also
+// underlines the part of span on the row.
+func (l *Logger) NewSpanError(row, col int, span Span, errmsg string) error {
+	excerpt := l.Excerpt(row, col, span)
+	if excerpt == "" {
+		// no source, as for a tree loaded from JSON
+		return fmt.Errorf("error: %s\n    at line %d, column %d", errmsg, row, col)
+	}
+	return fmt.Errorf("error: %s\n%s", errmsg, excerpt)
+}
+
+// Excerpt returns the line row of the source with a pointer at col under
+// it, the part of span on the row being underlined, or "" if the source
+// has no such line.
+func (l *Logger) Excerpt(row, col int, span Span) string {
+	if row < 1 || row >= len(l.lines) {
+		return ""
+	}
 	prefix := fmt.Sprintf("    %d | ", row)
 	lineMsg := fmt.Sprintf("%s%s", prefix, l.lines[row])
-	pointer := ""
-	for i := 1; i < len(prefix)+col; i++ {
-		pointer += " "
+
+	from, to := col, col+1 // columns underlined
+	if !span.IsZero() && span.Start.Row <= row && row <= span.End.Row {
+		line := l.lines[row]
+		from, to = len(line)-len(strings.TrimLeft(line, " \t"))+1, len(line)+1
+		if span.Start.Row == row {
+			from = span.Start.Col
+		}
+		if span.End.Row == row {
+			to = span.End.Col
+		}
+	}
+	pointer := strings.Repeat(" ", len(prefix))
+	for c := 1; c < to || c <= col; c++ {
+		switch {
+		case c == col:
+			pointer += "^"
+		case c >= from && c < to:
+			pointer += "~"
+		default:
+			pointer += " "
+		}
 	}
-	pointer += "^"
-	return fmt.Errorf("error: %s\n%s\n%s", errmsg, lineMsg, pointer)
+	return lineMsg + "\n" + strings.TrimRight(pointer, " ")
 }
 
 func (l *Logger) DPrintf(dflag int, format string, a ...interface{}) {
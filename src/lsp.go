@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// lspCommand serves the Language Server Protocol over stdin and stdout.
+func lspCommand(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s lsp\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	return NewLSPServer(os.Stdin, os.Stdout).Serve()
+}
+
+// LSPServer answers the requests of an editor about the Lox documents it
+// opened. Documents are analyzed again on each change.
+type LSPServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*lspDocument
+	shutdown bool
+}
+
+type lspDocument struct {
+	text     string
+	analysis *Analysis
+}
+
+func NewLSPServer(in io.Reader, out io.Writer) *LSPServer {
+	return &LSPServer{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*lspDocument),
+	}
+}
+
+// JSON-RPC messages
+
+type rpcMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   rpcError         `json:"error"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidParams  = -32602
+	rpcMethodNotFound = -32601
+	rpcInternalError  = -32603
+)
+
+var errExit = errors.New("exit without shutdown")
+
+// LSP structures, only with the fields used
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspHover struct {
+	Contents struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	} `json:"contents"`
+	Range lspRange `json:"range"`
+}
+
+type lspSymbol struct {
+	Name           string      `json:"name"`
+	Detail         string      `json:"detail,omitempty"`
+	Kind           int         `json:"kind"`
+	Range          lspRange    `json:"range"`
+	SelectionRange lspRange    `json:"selectionRange"`
+	Children       []lspSymbol `json:"children,omitempty"`
+}
+
+type lspCompletion struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// symbol and completion item kinds
+const (
+	symbolClass     = 5
+	symbolMethod    = 6
+	symbolInterface = 11
+	symbolFunction  = 12
+
+	completionFunction  = 3
+	completionVariable  = 6
+	completionClass     = 7
+	completionInterface = 8
+	completionKeyword   = 14
+)
+
+// Serve handles messages until the exit notification or the end of input.
+func (s *LSPServer) Serve() error {
+	for {
+		data, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg rpcMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if err := s.replyError(nil, rpcParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.handle(&msg); err != nil {
+			if err == errExit && s.shutdown {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *LSPServer) read() ([]byte, error) {
+	return readFrame(s.in)
+}
+
+// readFrame reads the content of the next message, framed by a
+// Content-Length header as in the language server and debug adapter
+// protocols.
+func readFrame(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		name, value := line[:colon], line[colon+1:]
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(in, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *LSPServer) write(msg interface{}) error {
+	return writeFrame(s.out, msg)
+}
+
+// writeFrame writes msg as JSON framed by a Content-Length header.
+func writeFrame(out io.Writer, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func (s *LSPServer) reply(id *json.RawMessage, result interface{}) error {
+	return s.write(rpcResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *LSPServer) replyError(id *json.RawMessage, code int, msg string) error {
+	return s.write(rpcErrorResponse{JSONRPC: "2.0", ID: id, Error: rpcError{Code: code, Message: msg}})
+}
+
+func (s *LSPServer) notify(method string, params interface{}) error {
+	return s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle answers a request or processes a notification, those have no id.
+func (s *LSPServer) handle(msg *rpcMessage) (err error) {
+	var result interface{}
+	defer func() {
+		// a failure of the analysis must not bring the server down
+		if r := recover(); r != nil {
+			if msg.ID != nil {
+				err = s.replyError(msg.ID, rpcInternalError, fmt.Sprint(r))
+			}
+		}
+	}()
+
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // full content on change
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "golox"},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "exit":
+		return errExit
+
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+		return s.sync(msg)
+
+	case "textDocument/definition", "textDocument/references", "textDocument/hover",
+		"textDocument/documentSymbol", "textDocument/completion":
+		var params lspPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, rpcInvalidParams, err.Error())
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if ok {
+			result = s.query(msg.Method, doc, &params)
+		}
+
+	default:
+		if msg.ID != nil {
+			return s.replyError(msg.ID, rpcMethodNotFound, "unknown method "+msg.Method)
+		}
+		return nil
+	}
+
+	if msg.ID == nil {
+		return nil
+	}
+	return s.reply(msg.ID, result)
+}
+
+// sync updates the opened documents and publishes their diagnostics.
+func (s *LSPServer) sync(msg *rpcMessage) error {
+	var params struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "textDocument/didOpen":
+		s.docs[uri] = &lspDocument{text: params.TextDocument.Text}
+	case "textDocument/didChange":
+		doc, ok := s.docs[uri]
+		if !ok || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return s.publish(uri, nil)
+	}
+
+	doc := s.docs[uri]
+	doc.analysis = Analyze(doc.text)
+	return s.publish(uri, doc)
+}
+
+func (s *LSPServer) publish(uri string, doc *lspDocument) error {
+	diagnostics := make([]lspDiagnostic, 0)
+	if doc != nil {
+		for _, problem := range doc.analysis.Problems {
+			diagnostics = append(diagnostics, lspDiagnostic{
+				Range:    doc.span(problem.Offset, problem.Length),
+				Severity: 1,
+				Source:   "golox",
+				Message:  problem.Msg,
+			})
+		}
+	}
+	return s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+func (s *LSPServer) query(method string, doc *lspDocument, params *lspPositionParams) interface{} {
+	a := doc.analysis
+	offset := doc.offset(params.Position)
+	uri := params.TextDocument.URI
+
+	switch method {
+	case "textDocument/definition":
+		locations := make([]lspLocation, 0)
+		for _, decl := range a.Definitions(offset) {
+			locations = append(locations, lspLocation{URI: uri, Range: doc.tokenRange(decl.Name)})
+		}
+		return locations
+
+	case "textDocument/references":
+		locations := make([]lspLocation, 0)
+		for _, decl := range a.Definitions(offset) {
+			for _, ref := range a.References(decl, params.Context.IncludeDeclaration) {
+				locations = append(locations, lspLocation{URI: uri, Range: doc.tokenRange(ref)})
+			}
+		}
+		return locations
+
+	case "textDocument/hover":
+		return doc.hover(offset)
+
+	case "textDocument/documentSymbol":
+		return doc.symbols(a.Statements)
+
+	case "textDocument/completion":
+		return doc.completions(offset)
+	}
+	return nil
+}
+
+func (doc *lspDocument) hover(offset int) interface{} {
+	a := doc.analysis
+	name, ok := a.NameAt(offset)
+	if !ok {
+		return nil
+	}
+	parts := make([]string, 0)
+	for _, decl := range a.Definitions(offset) {
+		text := "```lox\n" + Signature(decl) + "\n```"
+		if fun, ok := decl.Node.(*StmtFun); ok && decl.Kind != ParamDecl {
+			text += "\n\narity: " + Arity(fun)
+		}
+		if doc := a.DocComment(decl); doc != "" {
+			text += "\n\n" + doc
+		}
+		parts = append(parts, text)
+	}
+	if len(parts) == 0 {
+		if _, ok := NewInterpreter().globals[name.lexeme].(*BuildinFun); !ok || a.isProperty(name) {
+			return nil
+		}
+		parts = append(parts, "```lox\nfun "+name.lexeme+"\n```\n\nnative function")
+	}
+
+	hover := &lspHover{Range: doc.tokenRange(name)}
+	hover.Contents.Kind = "markdown"
+	hover.Contents.Value = strings.Join(parts, "\n\n---\n\n")
+	return hover
+}
+
+func (doc *lspDocument) symbols(statements []Stmt) []lspSymbol {
+	symbols := make([]lspSymbol, 0)
+	for _, statement := range statements {
+		switch stmt := statement.(type) {
+		case *StmtFun:
+			symbols = append(symbols, doc.symbol(stmt.Name, "fun", symbolFunction, doc.symbols(stmt.Body)))
+		case *StmtClass:
+			methods := make([]lspSymbol, 0)
+			for _, group := range [][]*StmtFun{stmt.Methods, stmt.StaticMethods, stmt.Getters, stmt.Setters} {
+				for _, method := range group {
+					methods = append(methods, doc.symbol(method.Name, "", symbolMethod, doc.symbols(method.Body)))
+				}
+			}
+			sort.Slice(methods, func(i, j int) bool {
+				return methods[i].SelectionRange.Start.Line < methods[j].SelectionRange.Start.Line
+			})
+			symbols = append(symbols, doc.symbol(stmt.Name, "class", symbolClass, methods))
+		case *StmtTrait:
+			methods := make([]lspSymbol, 0)
+			for _, method := range stmt.Methods {
+				methods = append(methods, doc.symbol(method.Name, "", symbolMethod, doc.symbols(method.Body)))
+			}
+			symbols = append(symbols, doc.symbol(stmt.Name, "trait", symbolInterface, methods))
+		case *StmtBlock:
+			symbols = append(symbols, doc.symbols(stmt.Statements)...)
+		}
+	}
+	return symbols
+}
+
+func (doc *lspDocument) symbol(name Token, detail string, kind int, children []lspSymbol) lspSymbol {
+	r := doc.tokenRange(name)
+	return lspSymbol{Name: name.lexeme, Detail: detail, Kind: kind, Range: r, SelectionRange: r, Children: children}
+}
+
+func (doc *lspDocument) completions(offset int) []lspCompletion {
+	items := make([]lspCompletion, 0)
+	seen := make(map[string]bool)
+	for _, decl := range doc.analysis.Visible(offset) {
+		kind := completionVariable
+		switch decl.Kind {
+		case FunDecl:
+			kind = completionFunction
+		case ClassDecl:
+			kind = completionClass
+		case TraitDecl:
+			kind = completionInterface
+		}
+		seen[decl.Name.lexeme] = true
+		items = append(items, lspCompletion{Label: decl.Name.lexeme, Kind: kind, Detail: Signature(decl)})
+	}
+
+	natives := make([]string, 0)
+	for name := range NewInterpreter().globals {
+		if !seen[name] {
+			natives = append(natives, name)
+		}
+	}
+	sort.Strings(natives)
+	for _, name := range natives {
+		items = append(items, lspCompletion{Label: name, Kind: completionFunction, Detail: "native function"})
+	}
+
+	keywords := make([]string, 0, len(scannerKeywords))
+	for keyword := range scannerKeywords {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		items = append(items, lspCompletion{Label: keyword, Kind: completionKeyword})
+	}
+	return items
+}
+
+// Positions of LSP are lines and UTF-16 code units from 0.
+
+func (doc *lspDocument) position(offset int) lspPosition {
+	if offset > len(doc.text) {
+		offset = len(doc.text)
+	}
+	line := strings.Count(doc.text[:offset], "\n")
+	start := strings.LastIndexByte(doc.text[:offset], '\n') + 1
+	return lspPosition{Line: line, Character: utf16Len(doc.text[start:offset])}
+}
+
+func (doc *lspDocument) offset(pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		n := strings.IndexByte(doc.text[offset:], '\n')
+		if n < 0 {
+			return len(doc.text)
+		}
+		offset += n + 1
+	}
+	for units := 0; units < pos.Character && offset < len(doc.text) && doc.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		offset += size
+		units += utf16Len(string(r))
+	}
+	return offset
+}
+
+func (doc *lspDocument) span(offset, length int) lspRange {
+	return lspRange{Start: doc.position(offset), End: doc.position(offset + length)}
+}
+
+func (doc *lspDocument) tokenRange(token Token) lspRange {
+	return doc.span(token.offset, len(token.lexeme))
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
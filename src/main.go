@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"runtime/pprof"
 )
 
@@ -17,35 +19,59 @@ func init() {
 }
 
 func run(src string) error {
-	f, _ := os.OpenFile("profile", os.O_CREATE|os.O_RDWR, 0644)
-	defer f.Close()
-	pprof.StartCPUProfile(f)
-	defer pprof.StopCPUProfile()
+	return runSource(src, interpreter, resolver)
+}
+
+// runSource runs src with the given interpreter and resolver.
+func runSource(src string, interpreter *Interpreter, resolver *Resolver) error {
+	statements, resolution, err := compile(src, resolver)
+	if err != nil {
+		return err
+	}
+	interpreter.SetResolution(resolution)
+	return interpreter.Interprete(statements)
+}
 
+// compile scans, parses and resolves src, then optimizes the statements if
+// enabled.
+func compile(src string, resolver *Resolver) ([]Stmt, *Resolution, error) {
 	logger.Reset(src, os.Stdout, os.Stderr)
 
 	scanner := NewScanner(src)
 	tokens, err := scanner.Tokens()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	parser := NewParser(tokens)
 	statements, err := parser.Parse()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	locals, err := resolver.Resolve(statements)
+	resolution, err := resolver.Resolve(statements)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	interpreter.SetLocals(locals)
-	return interpreter.Interprete(statements)
+	if *optimize {
+		statements = NewOptimizer().Optimize(statements)
+	}
+	if *dumpAST {
+		if err := NewAstPrinter().Fprint(os.Stderr, statements); err != nil {
+			return nil, nil, err
+		}
+	}
+	return statements, resolution, nil
 }
 
 func runFile(filename string) error {
+	if *coverageOut != "" {
+		return runCoverage(filename)
+	}
+	if profiling() {
+		return runProfiled(filename)
+	}
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
@@ -79,17 +105,172 @@ func runPrompt() error {
 	}
 }
 
+var (
+	maxDepth      = flag.Int("max-depth", DefaultMaxCallDepth, "maximum call depth, 0 for no limit")
+	maxSteps      = flag.Int("max-steps", 0, "maximum number of executed steps, 0 for no limit")
+	timeout       = flag.Duration("timeout", 0, "maximum execution time, 0 for no limit")
+	allocBudget   = flag.Int64("alloc-budget", 0, "maximum total bytes allocated by a script, freed memory included, 0 for no limit")
+	memstats      = flag.Bool("memstats", false, "print memory statistics after running a script")
+	optimize      = flag.Bool("optimize", false, "fold constants and remove dead code before running")
+	dumpAST       = flag.Bool("dump-ast", false, "print the syntax tree, after optimization if enabled, to stderr")
+	cpuprofile    = flag.String("cpuprofile", "", "write a CPU profile of the interpreter to `file`")
+	memprofile    = flag.String("memprofile", "", "write a heap profile of the interpreter to `file`")
+	sandbox       = flag.Bool("sandbox", false, "only allow natives of granted capabilities")
+	grant         = flag.String("grant", "", "comma separated capabilities granted in sandbox mode (time, io, os)")
+	trace         = flag.Bool("trace", false, "print the calls of the script with their arguments and results to stderr")
+	coverageOut   = flag.String("coverage", "", "write the coverage of the script to `file`, see the cover command")
+	profileOut    = flag.String("profile", "", "write a pprof profile of the Lox functions of the script to `file`")
+	profileFolded = flag.String("profile-folded", "", "write the sampled stacks of the script to `file` in the folded format of flame graphs")
+	profileRate   = flag.Int("profile-rate", 1000, "number of samples a second taken by the profiler, 0 for a sample at every statement")
+	profileTop    = flag.Int("profile-top", 0, "print the `n` functions and lines of the script taking the most time to stderr")
+)
+
+// profiling tells if the script is profiled.
+func profiling() bool {
+	return *profileOut != "" || *profileFolded != "" || *profileTop > 0
+}
+
+// noObservers fails if the flags ask for hooks observing the script, which
+// command can't install along with the hooks of its debugger.
+func noObservers(command string) error {
+	if *trace || *coverageOut != "" || profiling() {
+		return fmt.Errorf("-trace, -coverage and -profile can't be used with %s", command)
+	}
+	return nil
+}
+
+// configure applies the limits and sandbox flags to interpreter.
+func configure(interpreter *Interpreter) error {
+	observers := 0
+	for _, on := range []bool{*trace, *coverageOut != "", profiling()} {
+		if on {
+			observers++
+		}
+	}
+	if observers > 1 {
+		return fmt.Errorf("-trace, -coverage and -profile can't be used together")
+	}
+
+	interpreter.SetLimits(Limits{
+		MaxCallDepth: *maxDepth,
+		MaxSteps:     *maxSteps,
+		Timeout:      *timeout,
+		AllocBudget:  *allocBudget,
+	})
+
+	if *sandbox {
+		caps, err := ParseCapabilities(*grant)
+		if err != nil {
+			return err
+		}
+		interpreter.SetSandbox(NewSandbox(caps...))
+	}
+	if *trace {
+		interpreter.SetHooks(NewTracer(os.Stderr))
+	}
+	return nil
+}
+
+// commands are the subcommands of golox, selected by the first argument.
+var commands = map[string]func(args []string) error{
+	"ast":    astCommand,
+	"bench":  benchCommand,
+	"cover":  coverCommand,
+	"dap":    dapCommand,
+	"debug":  debugCommand,
+	"fmt":    fmtCommand,
+	"lint":   lintCommand,
+	"lsp":    lspCommand,
+	"tokens": tokensCommand,
+}
+
+// startProfiling starts the profiles requested by flags, the returned
+// function stops them.
+func startProfiling() (stop func(), err error) {
+	stop = func() {}
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		stop = func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		}
+	}
+	if *memprofile != "" {
+		stopCPU := stop
+		stop = func() {
+			stopCPU()
+			f, err := os.Create(*memprofile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	return stop, nil
+}
+
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Fprintf(os.Stderr, "usage %s [filename]", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage %s [flags] [filename]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] ast [-json] [-load] [-run] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] bench [bench flags] files...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s cover [-format text|html|lcov] [-o file] profiles...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] debug file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] dap\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s fmt [-check | -write] [files...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lint [-json] [-enable rules] [-disable rules] [files...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lsp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s tokens [-json] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		if err := cmd(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			stopProfiling()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		return
+	}
+
+	if err := configure(interpreter); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 
 	// script file
-	if len(os.Args) == 2 {
-		if err := runFile(os.Args[1]); err != nil {
+	if flag.NArg() == 1 {
+		if err := runFile(flag.Arg(0)); err != nil {
 			fmt.Println(err)
 		}
+		if *memstats {
+			interpreter.MemStats().Fprint(os.Stderr)
+		}
 		return
 	}
 
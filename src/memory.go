@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MemKind classifies accounted allocations.
+type MemKind int
+
+const (
+	MemStrings MemKind = iota
+	MemInstances
+	MemClosures
+	MemCollections
+	MemEnvironments
+	memKinds
+)
+
+func (k MemKind) String() string {
+	switch k {
+	case MemStrings:
+		return "strings"
+	case MemInstances:
+		return "instances"
+	case MemClosures:
+		return "closures"
+	case MemCollections:
+		return "collections"
+	case MemEnvironments:
+		return "environments"
+	default:
+		return ""
+	}
+}
+
+// Approximate sizes in bytes of the values allocated by a program, they
+// follow the layout of the Go values backing them on 64 bit platforms.
+const (
+	stringHeaderSize = 16 // string header, plus one byte per character
+	instanceSize     = 64 // LoxInstance and its empty field map
+	fieldSize        = 48 // map entry of a field, plus the name
+	closureSize      = 48 // LoxFunction
+	listSize         = 48 // LoxList and its slice header
+	valueSize        = 16 // interface value in a list or environment
+	environmentSize  = 32 // Environment and its slice header
+)
+
+var errOutOfMemory = errors.New("Out of memory.")
+
+// MemStats reports the approximate bytes allocated by the current run.
+type MemStats struct {
+	Bytes [memKinds]int64
+}
+
+func (s MemStats) Total() int64 {
+	var total int64
+	for _, n := range s.Bytes {
+		total += n
+	}
+	return total
+}
+
+// Fprint writes the statistics in a human readable form.
+func (s MemStats) Fprint(w io.Writer) {
+	for k := MemKind(0); k < memKinds; k++ {
+		fmt.Fprintf(w, "%-13s %d\n", k.String()+":", s.Bytes[k])
+	}
+	fmt.Fprintf(w, "%-13s %d\n", "total:", s.Total())
+}
+
+// MemStats returns the allocation statistics of the last run.
+func (i *Interpreter) MemStats() MemStats {
+	return i.mem
+}
+
+// alloc accounts for size bytes of kind, it fails once the total exceeds
+// the allocation budget. Values are not tracked once allocated, so the
+// budget bounds all the allocations of a run rather than the live heap.
+func (i *Interpreter) alloc(kind MemKind, size int) error {
+	i.mem.Bytes[kind] += int64(size)
+	i.allocated += int64(size)
+	if i.limits.AllocBudget > 0 && i.allocated > i.limits.AllocBudget {
+		return errOutOfMemory
+	}
+	return nil
+}
+
+// memoryUsage returns the total bytes allocated by the script so far, which
+// never decreases as memory given back is not accounted for, see alloc.
+var BuildinMemoryUsage *BuildinFun = &BuildinFun{
+	name:  "memoryUsage",
+	arity: 0,
+	call: func(i *Interpreter, args []interface{}) (interface{}, error) {
+		return float64(i.allocated), nil
+	},
+}
@@ -0,0 +1,285 @@
+package main
+
+// Optimizer rewrites a resolved program into a cheaper equivalent one. It
+// folds operators on constants, drops branches and loops whose condition is
+// constant and statements following a return.
+//
+// Variable, assignment, this and super nodes are kept as they are, since the
+// resolution refers to them, and scoped statements are rewritten in place.
+// Operations which fail at runtime are not folded so that they still report
+// their error at the same line.
+type Optimizer struct {
+	consts *Interpreter // evaluates operators on constants
+}
+
+var (
+	_ ExprVisitor = &Optimizer{}
+	_ StmtVisitor = &Optimizer{}
+)
+
+func NewOptimizer() *Optimizer {
+	consts := NewInterpreter()
+	consts.SetLimits(Limits{})
+	return &Optimizer{consts: consts}
+}
+
+func (o *Optimizer) Optimize(statements []Stmt) []Stmt {
+	return o.stmts(statements)
+}
+
+func (o *Optimizer) expr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	result, _ := expr.Accept(o)
+	return result.(Expr)
+}
+
+// stmt returns the optimized statement, or nil if it does nothing.
+func (o *Optimizer) stmt(stmt Stmt) Stmt {
+	if stmt == nil {
+		return nil
+	}
+	result, _ := stmt.Accept(o)
+	if result == nil {
+		return nil
+	}
+	return result.(Stmt)
+}
+
+// stmts optimizes a statement list, dropping what follows a return.
+func (o *Optimizer) stmts(statements []Stmt) []Stmt {
+	result := make([]Stmt, 0, len(statements))
+	for _, statement := range statements {
+		s := o.stmt(statement)
+		if s == nil {
+			continue
+		}
+		result = append(result, s)
+		if _, ok := s.(*StmtReturn); ok {
+			break
+		}
+	}
+	return result
+}
+
+func (o *Optimizer) funs(funs []*StmtFun) {
+	for _, fun := range funs {
+		o.VisitFun(fun)
+	}
+}
+
+// cond optimizes an expression only used for its truthiness, where !!x is
+// the same as x.
+func (o *Optimizer) cond(expr Expr) Expr {
+	expr = o.expr(expr)
+	for {
+		switch e := expr.(type) {
+		case *ExprGrouping:
+			expr = e.Expression
+			continue
+		case *ExprUnary:
+			if inner, ok := e.Expression.(*ExprUnary); ok &&
+				e.UnaryOperator.Type() == BANG && inner.UnaryOperator.Type() == BANG {
+				expr = inner.Expression
+				continue
+			}
+		case *ExprLogical:
+			e.Left = o.cond(e.Left)
+			e.Right = o.cond(e.Right)
+		}
+		return expr
+	}
+}
+
+// constant returns the value of a constant expression.
+func constant(expr Expr) (interface{}, bool) {
+	literal, ok := expr.(*ExprLiteral)
+	if !ok {
+		return nil, false
+	}
+	return literal.Value, true
+}
+
+// fold evaluates an operator whose operands are constants, it returns expr
+// unchanged if the evaluation fails.
+func (o *Optimizer) fold(expr Expr) Expr {
+	value, err := expr.Accept(o.consts)
+	if err != nil {
+		return expr
+	}
+	literal := &ExprLiteral{Value: value}
+	literal.SetSpan(expr.Span())
+	return literal
+}
+
+func (o *Optimizer) VisitLiteral(expr *ExprLiteral) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitVariable(expr *ExprVariable) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitAssign(expr *ExprAssign) (interface{}, error) {
+	expr.Value = o.expr(expr.Value)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitUnary(expr *ExprUnary) (interface{}, error) {
+	expr.Expression = o.expr(expr.Expression)
+	if _, ok := constant(expr.Expression); ok {
+		return o.fold(expr), nil
+	}
+	return expr, nil
+}
+
+func (o *Optimizer) VisitGrouping(expr *ExprGrouping) (interface{}, error) {
+	expr.Expression = o.expr(expr.Expression)
+	if _, ok := constant(expr.Expression); ok {
+		return expr.Expression, nil
+	}
+	return expr, nil
+}
+
+func (o *Optimizer) VisitBinary(expr *ExprBinary) (interface{}, error) {
+	expr.Left = o.expr(expr.Left)
+	expr.Right = o.expr(expr.Right)
+	_, lok := constant(expr.Left)
+	_, rok := constant(expr.Right)
+	if lok && rok {
+		return o.fold(expr), nil
+	}
+	return expr, nil
+}
+
+func (o *Optimizer) VisitLogical(expr *ExprLogical) (interface{}, error) {
+	expr.Left = o.expr(expr.Left)
+	expr.Right = o.expr(expr.Right)
+	left, ok := constant(expr.Left)
+	if !ok {
+		return expr, nil
+	}
+	// the result is the left operand when it short circuits
+	if isTruthy(left) == (expr.Operator.Type() == OR) {
+		return expr.Left, nil
+	}
+	return expr.Right, nil
+}
+
+func (o *Optimizer) VisitCall(expr *ExprCall) (interface{}, error) {
+	expr.Callee = o.expr(expr.Callee)
+	for n, arg := range expr.Args {
+		expr.Args[n] = o.expr(arg)
+	}
+	return expr, nil
+}
+
+func (o *Optimizer) VisitGet(expr *ExprGet) (interface{}, error) {
+	expr.Object = o.expr(expr.Object)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitSet(expr *ExprSet) (interface{}, error) {
+	expr.Object = o.expr(expr.Object)
+	expr.Value = o.expr(expr.Value)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitThis(expr *ExprThis) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitSuper(expr *ExprSuper) (interface{}, error) {
+	return expr, nil
+}
+
+func (o *Optimizer) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	expr.Object = o.expr(expr.Object)
+	expr.Index = o.expr(expr.Index)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	expr.Expression = o.expr(expr.Expression)
+	return expr, nil
+}
+
+func (o *Optimizer) VisitExpression(stmt *StmtExpression) (interface{}, error) {
+	stmt.Expression = o.expr(stmt.Expression)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitPrint(stmt *StmtPrint) (interface{}, error) {
+	stmt.Expression = o.expr(stmt.Expression)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitVar(stmt *StmtVar) (interface{}, error) {
+	stmt.Initializer = o.expr(stmt.Initializer)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitBlock(stmt *StmtBlock) (interface{}, error) {
+	stmt.Statements = o.stmts(stmt.Statements)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitIf(stmt *StmtIf) (interface{}, error) {
+	stmt.Cond = o.cond(stmt.Cond)
+	stmt.Then = o.stmt(stmt.Then)
+	stmt.Else = o.stmt(stmt.Else)
+
+	cond, ok := constant(stmt.Cond)
+	if !ok {
+		if stmt.Then == nil {
+			// the interpreter expects a then branch
+			stmt.Then = &StmtBlock{Statements: []Stmt{}}
+			stmt.Then.SetSpan(Span{Start: stmt.Span().End, End: stmt.Span().End})
+		}
+		return stmt, nil
+	}
+	if isTruthy(cond) {
+		return stmt.Then, nil
+	}
+	return stmt.Else, nil
+}
+
+func (o *Optimizer) VisitWhile(stmt *StmtWhile) (interface{}, error) {
+	stmt.Cond = o.cond(stmt.Cond)
+	if cond, ok := constant(stmt.Cond); ok && !isTruthy(cond) {
+		return nil, nil
+	}
+	stmt.Body = o.stmt(stmt.Body)
+	if stmt.Body == nil {
+		stmt.Body = &StmtBlock{Statements: []Stmt{}}
+		stmt.Body.SetSpan(Span{Start: stmt.Span().End, End: stmt.Span().End})
+	}
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitFun(stmt *StmtFun) (interface{}, error) {
+	for n, def := range stmt.Defaults {
+		stmt.Defaults[n] = o.expr(def)
+	}
+	stmt.Body = o.stmts(stmt.Body)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitReturn(stmt *StmtReturn) (interface{}, error) {
+	stmt.Value = o.expr(stmt.Value)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitClass(stmt *StmtClass) (interface{}, error) {
+	o.funs(stmt.Methods)
+	o.funs(stmt.StaticMethods)
+	o.funs(stmt.Getters)
+	o.funs(stmt.Setters)
+	return stmt, nil
+}
+
+func (o *Optimizer) VisitTrait(stmt *StmtTrait) (interface{}, error) {
+	o.funs(stmt.Methods)
+	return stmt, nil
+}
@@ -1,7 +1,5 @@
 package main
 
-import "fmt"
-
 type Parser struct {
 	tokens  []Token
 	current int
@@ -49,6 +47,14 @@ func (p *Parser) check(tokens ...TokenType) bool {
 	return false
 }
 
+// checkNext checks the type of the token after the current one.
+func (p *Parser) checkNext(token TokenType) bool {
+	if p.current+1 >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+1].Type() == token
+}
+
 func (p *Parser) match(tokens ...TokenType) bool {
 	if p.check(tokens...) {
 		p.advance()
@@ -57,6 +63,12 @@ func (p *Parser) match(tokens ...TokenType) bool {
 	return false
 }
 
+// span returns the span from the start of token start to the end of the last
+// token consumed.
+func (p *Parser) span(start Token) Span {
+	return Span{Start: start.start(), End: p.previous().end()}
+}
+
 func (p *Parser) consume(t TokenType, msg string) Token {
 	if p.check(t) {
 		return p.advance()
@@ -72,15 +84,25 @@ func (p *Parser) consume(t TokenType, msg string) Token {
 // declaration    → varDecl
 //                → funDecl
 //                | classDecl
+//                | traitDecl
 //                | statement ;
 //
 // varDecl        → VAR IDENTIFIER "=" expression ;
 //
 // funDecl        → "fun" function ;
 // function       → IDENTIFIER "(" parameters? ")" blockStmt ;
-// parameters     → IDENTIFIER ("," IDENTIFIER)* ;
+// parameters     → "..." IDENTIFIER
+//                | param ( "," param )* ( "," "..." IDENTIFIER )? ;
+// param          → IDENTIFIER ( "=" expression )? ;
+//
+// classDecl      → "class" IDENTIFIER ( "<" IDENTIFIER )?
+//                  ( "with" IDENTIFIER ( "," IDENTIFIER )* )? "{" member* "}" ;
+// member         → "class" function
+//                | "set" function
+//                | IDENTIFIER blockStmt
+//                | function ;
 //
-// classDecl      → "class" IDENTIFIER ( "<" IDENTIFIER )? "{" function* "}" ;
+// traitDecl      → "trait" IDENTIFIER "{" function* "}" ;
 //
 // statement      → exprStmt
 //                | printStmt
@@ -105,15 +127,19 @@ func (p *Parser) consume(t TokenType, msg string) Token {
 // returnStmt     → "return" expression? ";" ;
 //
 
-func (p *Parser) Parse() ([]Stmt, error) {
+func (p *Parser) Parse() (statements []Stmt, err error) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			fmt.Println(r)
+			e, ok := r.(*LoxError)
+			if !ok {
+				panic(r)
+			}
+			statements, err = nil, e
 		}
 	}()
 
-	statements := make([]Stmt, 0)
+	statements = make([]Stmt, 0)
 	for !p.match(EOF) {
 		statement, err := p.declaration()
 		if err != nil {
@@ -125,6 +151,86 @@ func (p *Parser) Parse() ([]Stmt, error) {
 	return statements, nil
 }
 
+// ParseExpression parses tokens holding a single expression, such as an
+// expression evaluated by the debugger.
+func (p *Parser) ParseExpression() (expr Expr, err error) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			e, ok := r.(*LoxError)
+			if !ok {
+				panic(r)
+			}
+			expr, err = nil, e
+		}
+	}()
+
+	expr, err = p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.consume(EOF, "Expect end of expression.")
+	return expr, nil
+}
+
+// ParseAll parses the whole program for tools which report every error. It
+// skips to the next statement after an error and returns the statements
+// parsed successfully.
+func (p *Parser) ParseAll() ([]Stmt, []*LoxError) {
+	statements := make([]Stmt, 0)
+	errs := make([]*LoxError, 0)
+	for !p.match(EOF) {
+		start := p.current
+		statement, err := p.recoverDeclaration()
+		if err == nil {
+			statements = append(statements, statement)
+			continue
+		}
+		errs = append(errs, err)
+		if p.current == start {
+			p.advance()
+		}
+		p.synchronize()
+	}
+	return statements, errs
+}
+
+func (p *Parser) recoverDeclaration() (statement Stmt, lerr *LoxError) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			e, ok := r.(*LoxError)
+			if !ok {
+				panic(r)
+			}
+			statement, lerr = nil, e
+		}
+	}()
+
+	statement, err := p.declaration()
+	if err != nil {
+		if e, ok := err.(*LoxError); ok {
+			return nil, e
+		}
+		return nil, NewLoxError(ParseError, p.previous(), err.Error())
+	}
+	return statement, nil
+}
+
+// synchronize discards tokens until the probable start of a statement.
+func (p *Parser) synchronize() {
+	for !p.check(EOF) && !p.atEnd() {
+		if p.previous().typ == SEMICOLON {
+			return
+		}
+		switch p.peek().typ {
+		case CLASS, TRAIT, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN:
+			return
+		}
+		p.advance()
+	}
+}
+
 func (p *Parser) declaration() (Stmt, error) {
 	if p.match(VAR) {
 		return p.varDeclaration()
@@ -135,10 +241,14 @@ func (p *Parser) declaration() (Stmt, error) {
 	if p.match(CLASS) {
 		return p.classDecl()
 	}
+	if p.match(TRAIT) {
+		return p.traitDecl()
+	}
 	return p.statement()
 }
 
 func (p *Parser) varDeclaration() (Stmt, error) {
+	start := p.previous()
 	name := p.consume(IDENTIFIER, "need identifier")
 
 	var initializer Expr
@@ -152,21 +262,31 @@ func (p *Parser) varDeclaration() (Stmt, error) {
 
 	p.consume(SEMICOLON, "Expect ';' after statement.")
 
-	return &StmtVar{Name: name, Initializer: initializer}, nil
+	stmt := &StmtVar{Name: name, Initializer: initializer}
+	stmt.SetSpan(p.span(start))
+	return stmt, nil
 }
 
 func (p *Parser) funDecl() (Stmt, error) {
+	start := p.previous()
 	value := p.consume(IDENTIFIER, "expect identifier")
-	name := value.Value().(string)
+	fun, err := p.function(value)
+	if err != nil {
+		return nil, err
+	}
+	fun.SetSpan(p.span(start))
+	return fun, nil
+}
 
+func (p *Parser) function(name Token) (*StmtFun, error) {
 	p.consume(LEFT_PAREN, "expect (")
 
-	var params []string
-	if p.check(RIGHT_PAREN) {
-		params = make([]string, 0)
-	} else {
+	params := make([]Token, 0)
+	defaults := make([]Expr, 0)
+	var rest *Token
+	if !p.check(RIGHT_PAREN) {
 		var err error
-		params, err = p.parameters()
+		params, defaults, rest, err = p.parameters()
 		if err != nil {
 			return nil, err
 		}
@@ -180,59 +300,191 @@ func (p *Parser) funDecl() (Stmt, error) {
 		return nil, err
 	}
 
-	return &StmtFun{
-		Name:   name,
-		Params: params,
-		Body:   body.(*StmtBlock).Statements,
-	}, nil
+	fun := &StmtFun{
+		Name:     name,
+		Params:   params,
+		Defaults: defaults,
+		Rest:     rest,
+		Body:     body.(*StmtBlock).Statements,
+	}
+	fun.SetSpan(p.span(name))
+	return fun, nil
 }
 
-func (p *Parser) parameters() ([]string, error) {
-	params := make([]string, 0)
+// getter parses a method declared without a parameter list.
+func (p *Parser) getter(name Token) (*StmtFun, error) {
+	p.consume(LEFT_BRACE, "expect {")
+
+	body, err := p.blockStmt()
+	if err != nil {
+		return nil, err
+	}
+
+	fun := &StmtFun{
+		Name:     name,
+		Params:   make([]Token, 0),
+		Defaults: make([]Expr, 0),
+		Body:     body.(*StmtBlock).Statements,
+	}
+	fun.SetSpan(p.span(name))
+	return fun, nil
+}
+
+// parameters parses the parameter list of a function. defaults holds the
+// default value of each parameter, nil for required parameters, and rest is
+// the name of the rest parameter if there is one.
+func (p *Parser) parameters() (params []Token, defaults []Expr, rest *Token, err error) {
+	params = make([]Token, 0)
+	defaults = make([]Expr, 0)
 	for {
+		if p.match(ELLIPSIS) {
+			param := p.consume(IDENTIFIER, "Expect rest parameter name.")
+			rest = &param
+			if p.check(COMMA) {
+				panic(NewLoxError(ParseError, p.peek(), "Rest parameter must be last."))
+			}
+			break
+		}
+
 		param := p.consume(IDENTIFIER, "expect identifier")
-		params = append(params, param.Value().(string))
+
+		var value Expr
+		if p.match(EQUAL) {
+			value, err = p.expression()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		} else if len(defaults) > 0 && defaults[len(defaults)-1] != nil {
+			panic(NewLoxError(ParseError, param, "Parameter without default value follows parameter with default value."))
+		}
+
+		params = append(params, param)
+		defaults = append(defaults, value)
 		if !p.match(COMMA) {
 			break
 		}
 	}
-	return params, nil
+	return params, defaults, rest, nil
 }
 
 func (p *Parser) classDecl() (Stmt, error) {
+	start := p.previous()
 	token := p.consume(IDENTIFIER, "expect indentifier")
 
 	var superclass *ExprVariable
 	if p.match(LESS) {
 		t := p.consume(IDENTIFIER, "Expect superclass name")
-		superclass = &ExprVariable{t}
+		superclass = &ExprVariable{Name: t}
+		superclass.SetSpan(tokenSpan(t))
+	}
+
+	traits := make([]*ExprVariable, 0)
+	if p.match(WITH) {
+		for {
+			t := p.consume(IDENTIFIER, "Expect trait name.")
+			trait := &ExprVariable{Name: t}
+			trait.SetSpan(tokenSpan(t))
+			traits = append(traits, trait)
+			if !p.match(COMMA) {
+				break
+			}
+		}
 	}
 
 	p.consume(LEFT_BRACE, "expect {")
 
 	methods := make([]*StmtFun, 0)
+	staticMethods := make([]*StmtFun, 0)
+	getters := make([]*StmtFun, 0)
+	setters := make([]*StmtFun, 0)
 	for !p.check(RIGHT_BRACE) && !p.atEnd() {
-		fun, err := p.funDecl()
+		// static method
+		if p.match(CLASS) {
+			start := p.previous()
+			name := p.consume(IDENTIFIER, "Expect static method name.")
+			fun, err := p.function(name)
+			if err != nil {
+				return nil, err
+			}
+			fun.SetSpan(p.span(start))
+			staticMethods = append(staticMethods, fun)
+			continue
+		}
+
+		// setter, "set" is only special when followed by the property name
+		if p.check(IDENTIFIER) && p.peek().lexeme == "set" && p.checkNext(IDENTIFIER) {
+			start := p.advance()
+			name := p.advance()
+			fun, err := p.function(name)
+			if err != nil {
+				return nil, err
+			}
+			fun.SetSpan(p.span(start))
+			if len(fun.Params) != 1 || fun.Rest != nil {
+				panic(NewLoxError(ParseError, name, "A setter must have exactly one parameter."))
+			}
+			setters = append(setters, fun)
+			continue
+		}
+
+		name := p.consume(IDENTIFIER, "Expect method name.")
+
+		// getter
+		if p.check(LEFT_BRACE) {
+			fun, err := p.getter(name)
+			if err != nil {
+				return nil, err
+			}
+			getters = append(getters, fun)
+			continue
+		}
+
+		fun, err := p.function(name)
 		if err != nil {
 			return nil, err
 		}
+		methods = append(methods, fun)
+	}
 
-		// TODO: error to logger
-		funNode, ok := fun.(*StmtFun)
-		if !ok {
-			panic("Programming error: Expect type StmtFun")
-		}
+	p.consume(RIGHT_BRACE, "expect }")
+
+	class := &StmtClass{
+		Name:          token,
+		Superclass:    superclass,
+		Traits:        traits,
+		Methods:       methods,
+		StaticMethods: staticMethods,
+		Getters:       getters,
+		Setters:       setters,
+	}
+	class.SetSpan(p.span(start))
+	return class, nil
+}
 
-		methods = append(methods, funNode)
+func (p *Parser) traitDecl() (Stmt, error) {
+	start := p.previous()
+	token := p.consume(IDENTIFIER, "Expect trait name.")
+
+	p.consume(LEFT_BRACE, "expect {")
+
+	methods := make([]*StmtFun, 0)
+	for !p.check(RIGHT_BRACE) && !p.atEnd() {
+		name := p.consume(IDENTIFIER, "Expect method name.")
+		fun, err := p.function(name)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, fun)
 	}
 
 	p.consume(RIGHT_BRACE, "expect }")
 
-	return &StmtClass{
-		Name:       token.lexeme,
-		Superclass: superclass,
-		Methods:    methods,
-	}, nil
+	trait := &StmtTrait{
+		Name:    token,
+		Methods: methods,
+	}
+	trait.SetSpan(p.span(start))
+	return trait, nil
 }
 
 func (p *Parser) statement() (Stmt, error) {
@@ -258,24 +510,31 @@ func (p *Parser) statement() (Stmt, error) {
 }
 
 func (p *Parser) printStmt() (Stmt, error) {
+	keyword := p.previous()
 	value, err := p.expression()
 	if err != nil {
 		return nil, err
 	}
 	p.consume(SEMICOLON, "Expect ';' after statement.")
-	return &StmtPrint{Expression: value}, nil
+	stmt := &StmtPrint{Keyword: keyword, Expression: value}
+	stmt.SetSpan(p.span(keyword))
+	return stmt, nil
 }
 
 func (p *Parser) exprStmt() (Stmt, error) {
+	start := p.peek()
 	value, err := p.expression()
 	if err != nil {
 		return nil, err
 	}
 	p.consume(SEMICOLON, "Expect ';' after expression.")
-	return &StmtExpression{Expression: value}, nil
+	stmt := &StmtExpression{Expression: value}
+	stmt.SetSpan(p.span(start))
+	return stmt, nil
 }
 
 func (p *Parser) blockStmt() (Stmt, error) {
+	start := p.previous()
 	statements := make([]Stmt, 0)
 	for !p.atEnd() && !p.check(RIGHT_BRACE) {
 		statement, err := p.declaration()
@@ -285,12 +544,15 @@ func (p *Parser) blockStmt() (Stmt, error) {
 		statements = append(statements, statement)
 	}
 	p.consume(RIGHT_BRACE, "expect }")
-	return &StmtBlock{
+	block := &StmtBlock{
 		Statements: statements,
-	}, nil
+	}
+	block.SetSpan(p.span(start))
+	return block, nil
 }
 
 func (p *Parser) ifStmt() (Stmt, error) {
+	start := p.previous()
 	p.consume(LEFT_PAREN, "expect ( after if")
 
 	cond, err := p.expression()
@@ -313,14 +575,17 @@ func (p *Parser) ifStmt() (Stmt, error) {
 		}
 	}
 
-	return &StmtIf{
+	stmt := &StmtIf{
 		Cond: cond,
 		Then: thenBranch,
 		Else: elseBranch,
-	}, nil
+	}
+	stmt.SetSpan(p.span(start))
+	return stmt, nil
 }
 
 func (p *Parser) whileStmt() (Stmt, error) {
+	keyword := p.previous()
 	p.consume(LEFT_PAREN, "expect ( after while")
 
 	cond, err := p.expression()
@@ -335,13 +600,17 @@ func (p *Parser) whileStmt() (Stmt, error) {
 		return nil, err
 	}
 
-	return &StmtWhile{
-		Cond: cond,
-		Body: body,
-	}, nil
+	stmt := &StmtWhile{
+		Keyword: keyword,
+		Cond:    cond,
+		Body:    body,
+	}
+	stmt.SetSpan(p.span(keyword))
+	return stmt, nil
 }
 
 func (p *Parser) forStmt() (Stmt, error) {
+	keyword := p.previous()
 	p.consume(LEFT_PAREN, "expect ( after for")
 
 	var initializer Stmt
@@ -361,6 +630,7 @@ func (p *Parser) forStmt() (Stmt, error) {
 	}
 
 	var condition Expr
+	missing := p.peek().start() // where the condition would be
 	if !p.check(SEMICOLON) {
 		condition, err = p.expression()
 		if err != nil {
@@ -385,23 +655,32 @@ func (p *Parser) forStmt() (Stmt, error) {
 		return nil, err
 	}
 
+	// the statements of the desugared loop all have the span of the loop,
+	// but the increment and the condition
+	span := p.span(keyword)
 	if increment != nil {
+		step := &StmtExpression{Expression: increment}
+		step.SetSpan(increment.Span())
 		body = &StmtBlock{
 			Statements: []Stmt{
 				body,
-				&StmtExpression{increment},
+				step,
 			},
 		}
+		body.SetSpan(span)
 	}
 
 	if condition == nil {
-		condition = &ExprLiteral{true}
+		condition = &ExprLiteral{Value: true}
+		condition.SetSpan(Span{Start: missing, End: missing})
 	}
 
 	body = &StmtWhile{
-		Cond: condition,
-		Body: body,
+		Keyword: keyword,
+		Cond:    condition,
+		Body:    body,
 	}
+	body.SetSpan(span)
 
 	if initializer != nil {
 		body = &StmtBlock{
@@ -410,6 +689,7 @@ func (p *Parser) forStmt() (Stmt, error) {
 				body,
 			},
 		}
+		body.SetSpan(span)
 	}
 
 	return body, nil
@@ -429,10 +709,12 @@ func (p *Parser) returnStmt() (Stmt, error) {
 
 	p.consume(SEMICOLON, "expect ;")
 
-	return &StmtReturn{
+	stmt := &StmtReturn{
 		Keyword: keyword,
 		Value:   expr,
-	}, nil
+	}
+	stmt.SetSpan(p.span(keyword))
+	return stmt, nil
 }
 
 //
@@ -449,13 +731,14 @@ func (p *Parser) returnStmt() (Stmt, error) {
 // factor         → unary ( ( "/" | "*" ) unary )* ;
 // unary          → ( "!" | "-" ) unary
 //                | call ;
-// call           → primary ( "(" arguments? ")" | "." IDENTIFIER )* ;
+// call           → primary ( "(" arguments? ")" | "." IDENTIFIER | "[" expression "]" )* ;
 // primary        → NUMBER | STRING | "true" | "false" | "nil"
 //                | "(" expression ")"
 //                | IDENTIFIER ;
 //                | "super" "." IDENTIFIER ;
 //
-// arguments      → expression ("," expression)*
+// arguments      → argument ("," argument)*
+// argument       → "..."? expression
 //
 
 func (p *Parser) expression() (Expr, error) {
@@ -463,6 +746,7 @@ func (p *Parser) expression() (Expr, error) {
 }
 
 func (p *Parser) assignment() (Expr, error) {
+	start := p.peek()
 	expr, err := p.or()
 	if err != nil {
 		return nil, err
@@ -493,12 +777,14 @@ func (p *Parser) assignment() (Expr, error) {
 		default:
 			panic(NewLoxError(ParseError, tk, "Invalid assignment target."))
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) or() (Expr, error) {
+	start := p.peek()
 	expr, err := p.and()
 	if err != nil {
 		return nil, err
@@ -517,12 +803,14 @@ func (p *Parser) or() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) and() (Expr, error) {
+	start := p.peek()
 	expr, err := p.equality()
 	if err != nil {
 		return nil, err
@@ -541,12 +829,14 @@ func (p *Parser) and() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) equality() (Expr, error) {
+	start := p.peek()
 	expr, err := p.comparison()
 	if err != nil {
 		return nil, err
@@ -565,12 +855,14 @@ func (p *Parser) equality() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) comparison() (Expr, error) {
+	start := p.peek()
 	expr, err := p.term()
 	if err != nil {
 		return nil, err
@@ -589,12 +881,14 @@ func (p *Parser) comparison() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) term() (Expr, error) {
+	start := p.peek()
 	expr, err := p.factor()
 	if err != nil {
 		return nil, err
@@ -613,12 +907,14 @@ func (p *Parser) term() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) factor() (Expr, error) {
+	start := p.peek()
 	expr, err := p.unary()
 	if err != nil {
 		return nil, err
@@ -637,6 +933,7 @@ func (p *Parser) factor() (Expr, error) {
 			Operator: operator,
 			Right:    right,
 		}
+		expr.SetSpan(p.span(start))
 	}
 
 	return expr, nil
@@ -655,6 +952,7 @@ func (p *Parser) unary() (Expr, error) {
 			UnaryOperator: operator,
 			Expression:    unary,
 		}
+		expr.SetSpan(p.span(operator))
 
 		return expr, nil
 	}
@@ -663,6 +961,7 @@ func (p *Parser) unary() (Expr, error) {
 }
 
 func (p *Parser) call() (Expr, error) {
+	start := p.peek()
 	callee, err := p.primary()
 	if err != nil {
 		return nil, err
@@ -680,6 +979,7 @@ func (p *Parser) call() (Expr, error) {
 				Paren:  paren,
 				Args:   args,
 			}
+			callee.SetSpan(p.span(start))
 		} else if p.check(DOT) {
 			dot := p.advance()
 			field := p.consume(IDENTIFIER, "expect identifier after dot")
@@ -688,6 +988,20 @@ func (p *Parser) call() (Expr, error) {
 				Field:  field,
 				Dot:    dot,
 			}
+			callee.SetSpan(p.span(start))
+		} else if p.check(LEFT_BRACKET) {
+			bracket := p.advance()
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			p.consume(RIGHT_BRACKET, "Expect ']' after index.")
+			callee = &ExprIndex{
+				Object:  callee,
+				Bracket: bracket,
+				Index:   index,
+			}
+			callee.SetSpan(p.span(start))
 		} else {
 			break
 		}
@@ -697,8 +1011,18 @@ func (p *Parser) call() (Expr, error) {
 }
 
 func (p *Parser) primary() (Expr, error) {
-	if p.check(NUMBER, STRING, TRUE, FALSE, NIL) {
-		return &ExprLiteral{Value: p.advance().Value()}, nil
+	start := p.peek()
+
+	if p.match(NIL) {
+		expr := &ExprLiteral{Value: nil}
+		expr.SetSpan(p.span(start))
+		return expr, nil
+	}
+
+	if p.check(NUMBER, STRING, TRUE, FALSE) {
+		expr := &ExprLiteral{Value: p.advance().Value()}
+		expr.SetSpan(p.span(start))
+		return expr, nil
 	}
 
 	if p.check(LEFT_PAREN) {
@@ -711,29 +1035,37 @@ func (p *Parser) primary() (Expr, error) {
 
 		p.consume(RIGHT_PAREN, "Expect ')' after expression")
 
-		return &ExprGrouping{Expression: expr}, nil
+		grouping := &ExprGrouping{Expression: expr}
+		grouping.SetSpan(p.span(start))
+		return grouping, nil
 	}
 
 	if p.check(IDENTIFIER) {
-		return &ExprVariable{
+		expr := &ExprVariable{
 			Name: p.advance(),
-		}, nil
+		}
+		expr.SetSpan(p.span(start))
+		return expr, nil
 	}
 
 	if p.check(THIS) {
-		return &ExprThis{
+		expr := &ExprThis{
 			Keyword: p.advance(),
-		}, nil
+		}
+		expr.SetSpan(p.span(start))
+		return expr, nil
 	}
 
 	if p.check(SUPER) {
 		super := p.advance()
 		p.consume(DOT, "Expect '.' after 'super'.")
 		name := p.consume(IDENTIFIER, "Expect superclass method name.")
-		return &ExprSuper{
+		expr := &ExprSuper{
 			Keyword: super,
 			Method:  name,
-		}, nil
+		}
+		expr.SetSpan(p.span(start))
+		return expr, nil
 	}
 
 	panic(NewLoxError(ParseError, p.peek(), "Expect expression."))
@@ -746,21 +1078,35 @@ func (p *Parser) arguments() ([]Expr, error) {
 		return args, nil
 	}
 
-	arg, err := p.expression()
-	if err != nil {
-		return nil, err
-	}
-
-	args = append(args, arg)
-	for p.match(COMMA) {
-		arg, err = p.expression()
+	for {
+		arg, err := p.argument()
 		if err != nil {
 			return nil, err
 		}
 		args = append(args, arg)
+		if !p.match(COMMA) {
+			break
+		}
 	}
 
 	p.consume(RIGHT_PAREN, "expect )")
 
 	return args, nil
 }
+
+func (p *Parser) argument() (Expr, error) {
+	if p.check(ELLIPSIS) {
+		ellipsis := p.advance()
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		spread := &ExprSpread{
+			Ellipsis:   ellipsis,
+			Expression: expr,
+		}
+		spread.SetSpan(p.span(ellipsis))
+		return spread, nil
+	}
+	return p.expression()
+}
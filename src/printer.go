@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -158,6 +159,18 @@ func (p *AstPrinter) Print(statements []Stmt) {
 	}
 }
 
+// Fprint writes the trees of statements to w.
+func (p *AstPrinter) Fprint(w io.Writer, statements []Stmt) error {
+	for _, statement := range statements {
+		t, err := p.BuildStmt(statement)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", t.Print())
+	}
+	return nil
+}
+
 func (p *AstPrinter) BuildExpr(expr Expr) (Tree, error) {
 	result, err := expr.Accept(p)
 	if err != nil {
@@ -343,6 +356,36 @@ func (p *AstPrinter) VisitSuper(expr *ExprSuper) (interface{}, error) {
 	return t, nil
 }
 
+func (p *AstPrinter) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	t := NewTree("index")
+
+	obj, err := p.BuildExpr(expr.Object)
+	if err != nil {
+		return nil, err
+	}
+	t.AddTree(obj)
+
+	index, err := p.BuildExpr(expr.Index)
+	if err != nil {
+		return nil, err
+	}
+	t.AddTree(index)
+
+	return t, nil
+}
+
+func (p *AstPrinter) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	inner, err := p.BuildExpr(expr.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewTree("...")
+	t.AddTree(inner)
+
+	return t, nil
+}
+
 func (p *AstPrinter) VisitExpression(stmt *StmtExpression) (interface{}, error) {
 	t, err := p.BuildExpr(stmt.Expression)
 	if err != nil {
@@ -442,11 +485,22 @@ func (p *AstPrinter) VisitWhile(stmt *StmtWhile) (interface{}, error) {
 
 func (p *AstPrinter) VisitFun(stmt *StmtFun) (interface{}, error) {
 	t := NewTree("fun")
-	t.Add(stmt.Name)
+	t.Add(stmt.Name.lexeme)
 
 	params := t.Add("params")
 	for i := range stmt.Params {
-		params.Add(stmt.Params[i])
+		param := params.Add(stmt.Params[i].lexeme)
+		if stmt.Defaults[i] == nil {
+			continue
+		}
+		value, err := p.BuildExpr(stmt.Defaults[i])
+		if err != nil {
+			return nil, err
+		}
+		param.Add("default").AddTree(value)
+	}
+	if stmt.Rest != nil {
+		params.Add("..." + stmt.Rest.lexeme)
 	}
 
 	body := t.Add("body")
@@ -476,7 +530,14 @@ func (p *AstPrinter) VisitReturn(stmt *StmtReturn) (interface{}, error) {
 
 func (p *AstPrinter) VisitClass(stmt *StmtClass) (interface{}, error) {
 	t := NewTree("class")
-	t.Add(stmt.Name)
+	t.Add(stmt.Name.lexeme)
+
+	if len(stmt.Traits) > 0 {
+		traits := t.Add("traits")
+		for _, trait := range stmt.Traits {
+			traits.Add(trait.Name.lexeme)
+		}
+	}
 
 	methods := t.Add("methods")
 	for _, method := range stmt.Methods {
@@ -487,5 +548,42 @@ func (p *AstPrinter) VisitClass(stmt *StmtClass) (interface{}, error) {
 		methods.AddTree(fun)
 	}
 
+	if err := p.addFunctions(t, "static methods", stmt.StaticMethods); err != nil {
+		return nil, err
+	}
+	if err := p.addFunctions(t, "getters", stmt.Getters); err != nil {
+		return nil, err
+	}
+	if err := p.addFunctions(t, "setters", stmt.Setters); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// addFunctions adds a labeled subtree of functions, skipped if there is none.
+func (p *AstPrinter) addFunctions(t Tree, label string, funs []*StmtFun) error {
+	if len(funs) == 0 {
+		return nil
+	}
+	sub := t.Add(label)
+	for _, fun := range funs {
+		f, err := p.BuildStmt(fun)
+		if err != nil {
+			return err
+		}
+		sub.AddTree(f)
+	}
+	return nil
+}
+
+func (p *AstPrinter) VisitTrait(stmt *StmtTrait) (interface{}, error) {
+	t := NewTree("trait")
+	t.Add(stmt.Name.lexeme)
+
+	if err := p.addFunctions(t, "methods", stmt.Methods); err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Profiler is the hooks of the -profile mode, which sample the stack of Lox
+// functions of a script to attribute its running time to functions and
+// lines. A ticker marks when a sample is due and the sample is taken by the
+// interpreter at the next statement, so the time spent in a native is
+// counted for the statement calling it.
+type Profiler struct {
+	path   string
+	period time.Duration
+	due    int32 // set when a sample is due
+	ticker *time.Ticker
+	done   chan struct{}
+
+	frames []profileFrame // innermost last, the script first
+	calls  map[*StmtFun]int
+
+	start, last time.Time
+	duration    time.Duration
+	samples     map[string]*profileSample
+	order       []*profileSample // samples in the order first taken
+}
+
+type profileFrame struct {
+	fn    *StmtFun // nil for the script
+	lines []int    // lines of the statements being executed, innermost last
+	last  int      // line of the last statement run
+}
+
+// profileLocation is a line of a function.
+type profileLocation struct {
+	fn   *StmtFun
+	line int
+}
+
+// profileSample is the samples of a stack, innermost location first.
+type profileSample struct {
+	stack []profileLocation
+	count int64
+	nanos int64
+}
+
+// maxProfileDepth is the number of innermost frames kept in a sample, so
+// that a deep recursion in tail position does not make sampling slow.
+const maxProfileDepth = 256
+
+// NewProfiler returns a profiler of the script path taking rate samples a
+// second, or a sample at every statement if rate is 0, which counts the
+// statements run by each stack.
+func NewProfiler(path string, rate int) *Profiler {
+	var period time.Duration
+	if rate < 0 {
+		rate = 1
+	}
+	if rate > 0 {
+		period = time.Second / time.Duration(rate)
+	}
+	return &Profiler{
+		path:    path,
+		period:  period,
+		frames:  []profileFrame{{}},
+		calls:   make(map[*StmtFun]int),
+		samples: make(map[string]*profileSample),
+	}
+}
+
+var _ Hooks = &Profiler{}
+
+// Start starts sampling.
+func (p *Profiler) Start() {
+	p.start = time.Now()
+	p.last = p.start
+	if p.period == 0 {
+		atomic.StoreInt32(&p.due, 1)
+		return
+	}
+	p.ticker = time.NewTicker(p.period)
+	p.done = make(chan struct{})
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				atomic.StoreInt32(&p.due, 1)
+			case <-done:
+				return
+			}
+		}
+	}(p.ticker, p.done)
+}
+
+// Stop stops sampling.
+func (p *Profiler) Stop() {
+	if p.ticker != nil {
+		p.ticker.Stop()
+		close(p.done)
+	}
+	p.duration = time.Since(p.start)
+}
+
+func (f *profileFrame) line() int {
+	for n := len(f.lines) - 1; n >= 0; n-- {
+		if f.lines[n] > 0 {
+			return f.lines[n]
+		}
+	}
+	return f.last
+}
+
+// sample records the stack, with the time elapsed since the last sample.
+func (p *Profiler) sample() {
+	if p.period > 0 {
+		atomic.StoreInt32(&p.due, 0)
+	}
+	now := time.Now()
+	nanos := now.Sub(p.last).Nanoseconds()
+	p.last = now
+
+	frames := p.frames
+	if len(frames) > maxProfileDepth {
+		frames = frames[len(frames)-maxProfileDepth:]
+	}
+	var key strings.Builder
+	for n := len(frames) - 1; n >= 0; n-- {
+		fmt.Fprintf(&key, "%p:%d;", frames[n].fn, frames[n].line())
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &profileSample{stack: make([]profileLocation, 0, len(frames))}
+		for n := len(frames) - 1; n >= 0; n-- {
+			s.stack = append(s.stack, profileLocation{frames[n].fn, frames[n].line()})
+		}
+		p.samples[key.String()] = s
+		p.order = append(p.order, s)
+	}
+	s.count++
+	s.nanos += nanos
+}
+
+func (p *Profiler) Statement(stmt Stmt) error {
+	line := 0
+	if token, ok := nodeToken(stmt); ok {
+		line = token.row
+	}
+	frame := &p.frames[len(p.frames)-1]
+	frame.lines = append(frame.lines, line)
+	if line > 0 {
+		frame.last = line
+	}
+	if atomic.LoadInt32(&p.due) != 0 {
+		p.sample()
+	}
+	return nil
+}
+
+func (p *Profiler) StatementDone(stmt Stmt) {
+	frame := &p.frames[len(p.frames)-1]
+	frame.lines = frame.lines[:len(frame.lines)-1]
+}
+
+func (p *Profiler) Call(fn *LoxFunction, args []interface{}) {
+	p.calls[fn.definition]++
+	// reuse the lines of a frame popped before
+	if len(p.frames) < cap(p.frames) {
+		p.frames = p.frames[:len(p.frames)+1]
+		frame := &p.frames[len(p.frames)-1]
+		frame.fn, frame.lines, frame.last = fn.definition, frame.lines[:0], fn.definition.Name.row
+		return
+	}
+	p.frames = append(p.frames, profileFrame{fn: fn.definition, last: fn.definition.Name.row})
+}
+
+func (p *Profiler) Return(fn *LoxFunction, value interface{}, err error) {
+	p.frames = p.frames[:len(p.frames)-1]
+}
+
+func (p *Profiler) Assign(name Token, value interface{}) {}
+
+func (p *Profiler) Error(err error) {}
+
+// profileName returns the name of a function, <script> for the script.
+func profileName(fn *StmtFun) string {
+	if fn == nil {
+		return "<script>"
+	}
+	return fn.Name.lexeme
+}
+
+// WriteFolded writes the samples as folded stacks, the input of flame graph
+// tools: a line per stack with the functions from the outermost, separated
+// by semicolons, and the number of samples.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	counts := make(map[string]int64)
+	var stacks []string
+	for _, s := range p.order {
+		names := make([]string, len(s.stack))
+		for n, loc := range s.stack {
+			names[len(s.stack)-1-n] = profileName(loc.fn)
+		}
+		stack := strings.Join(names, ";")
+		if _, ok := counts[stack]; !ok {
+			stacks = append(stacks, stack)
+		}
+		counts[stack] += s.count
+	}
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, counts[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileStat is the time spent in a function or a line.
+type profileStat struct {
+	name      string
+	calls     int
+	self, cum int64 // nanoseconds
+}
+
+// WriteTop writes the time spent in the functions and lines taking the
+// most, directly (self) and with their calls (cum), and the calls of the
+// functions.
+func (p *Profiler) WriteTop(w io.Writer, top int) {
+	funcs := make(map[*StmtFun]*profileStat)
+	lines := make(map[profileLocation]*profileStat)
+	var total, count int64
+	for _, s := range p.order {
+		total += s.nanos
+		count += s.count
+		seenFuncs := make(map[*StmtFun]bool)
+		seenLines := make(map[profileLocation]bool)
+		for n, loc := range s.stack {
+			f, ok := funcs[loc.fn]
+			if !ok {
+				f = &profileStat{name: profileName(loc.fn), calls: p.calls[loc.fn]}
+				funcs[loc.fn] = f
+			}
+			l, ok := lines[loc]
+			if !ok {
+				l = &profileStat{name: fmt.Sprintf("%s:%d", profileName(loc.fn), loc.line)}
+				lines[loc] = l
+			}
+			if n == 0 {
+				f.self += s.nanos
+				l.self += s.nanos
+			}
+			if !seenFuncs[loc.fn] {
+				seenFuncs[loc.fn] = true
+				f.cum += s.nanos
+			}
+			if !seenLines[loc] {
+				seenLines[loc] = true
+				l.cum += s.nanos
+			}
+		}
+	}
+	// functions never sampled still have their calls counted
+	for fn, calls := range p.calls {
+		if _, ok := funcs[fn]; !ok {
+			funcs[fn] = &profileStat{name: profileName(fn), calls: calls}
+		}
+	}
+
+	fmt.Fprintf(w, "%s: %d samples, %s\n", p.path, count, time.Duration(total))
+	fstats := make([]*profileStat, 0, len(funcs))
+	for _, f := range funcs {
+		fstats = append(fstats, f)
+	}
+	writeProfileStats(w, "function", fstats, total, top, true)
+	lstats := make([]*profileStat, 0, len(lines))
+	for _, l := range lines {
+		lstats = append(lstats, l)
+	}
+	writeProfileStats(w, "line", lstats, total, top, false)
+}
+
+func writeProfileStats(w io.Writer, title string, stats []*profileStat, total int64, top int, calls bool) {
+	sort.Slice(stats, func(a, b int) bool {
+		x, y := stats[a], stats[b]
+		if x.self != y.self {
+			return x.self > y.self
+		}
+		if x.cum != y.cum {
+			return x.cum > y.cum
+		}
+		return x.name < y.name
+	})
+	if top > 0 && len(stats) > top {
+		stats = stats[:top]
+	}
+	if calls {
+		fmt.Fprintf(w, "%10s %12s %7s %12s %7s  %s\n", "calls", "self", "self%", "cum", "cum%", title)
+	} else {
+		fmt.Fprintf(w, "%10s %12s %7s %12s %7s  %s\n", "", "self", "self%", "cum", "cum%", title)
+	}
+	for _, s := range stats {
+		count := ""
+		if calls {
+			count = fmt.Sprint(s.calls)
+		}
+		fmt.Fprintf(w, "%10s %12s %6.2f%% %12s %6.2f%%  %s\n", count,
+			time.Duration(s.self), percent64(s.self, total),
+			time.Duration(s.cum), percent64(s.cum, total), s.name)
+	}
+}
+
+func percent64(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// WritePprof writes the samples as a gzipped pprof profile, with Lox
+// functions and lines as locations, readable by go tool pprof.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	var strs []string
+	strIndex := make(map[string]uint64)
+	str := func(s string) uint64 {
+		if n, ok := strIndex[s]; ok {
+			return n
+		}
+		strIndex[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+	str("")
+
+	funcIDs := make(map[*StmtFun]uint64)
+	var funcs []*StmtFun
+	locIDs := make(map[profileLocation]uint64)
+	var locs []profileLocation
+
+	var b protoBuffer
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(b *protoBuffer) {
+			b.uint64(1, str(typ))
+			b.uint64(2, str(unit))
+		})
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "cpu", "nanoseconds")
+	for _, s := range p.order {
+		ids := make([]uint64, len(s.stack))
+		for n, loc := range s.stack {
+			if _, ok := funcIDs[loc.fn]; !ok {
+				funcIDs[loc.fn] = uint64(len(funcs) + 1)
+				funcs = append(funcs, loc.fn)
+			}
+			if _, ok := locIDs[loc]; !ok {
+				locIDs[loc] = uint64(len(locs) + 1)
+				locs = append(locs, loc)
+			}
+			ids[n] = locIDs[loc]
+		}
+		b.message(2, func(b *protoBuffer) {
+			b.packed(1, ids)
+			b.packed(2, []uint64{uint64(s.count), uint64(s.nanos)})
+		})
+	}
+	for n, loc := range locs {
+		b.message(4, func(b *protoBuffer) {
+			b.uint64(1, uint64(n+1))
+			b.message(4, func(b *protoBuffer) {
+				b.uint64(1, funcIDs[loc.fn])
+				b.uint64(2, uint64(loc.line))
+			})
+		})
+	}
+	for n, fn := range funcs {
+		// pprof drops the <...> of names as template arguments
+		name, start := "script", 1
+		if fn != nil {
+			name, start = profileName(fn), fn.Name.row
+		}
+		b.message(5, func(b *protoBuffer) {
+			b.uint64(1, uint64(n+1))
+			b.uint64(2, str(name))
+			b.uint64(3, str(profileName(fn)))
+			b.uint64(4, str(p.path))
+			b.uint64(5, uint64(start))
+		})
+	}
+	// the strings are interned above, so they come last
+	for _, s := range strs {
+		b.string(6, s)
+	}
+	b.uint64(9, uint64(p.start.UnixNano()))
+	b.uint64(10, uint64(p.duration.Nanoseconds()))
+	valueType(11, "cpu", "nanoseconds")
+	b.uint64(12, uint64(p.period.Nanoseconds()))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer encodes protocol buffer messages.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+// string writes s even if empty, as the string table starts with "".
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.Bytes())
+}
+
+func (b *protoBuffer) message(field int, encode func(b *protoBuffer)) {
+	var m protoBuffer
+	encode(&m)
+	b.bytes(field, m.Bytes())
+}
+
+// runProfiled runs the script filename and saves its profile to the files
+// given by the -profile flags, even if the script fails.
+func runProfiled(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	profiler := NewProfiler(path, *profileRate)
+	interpreter.SetHooks(profiler)
+	profiler.Start()
+	runErr := run(string(data))
+	profiler.Stop()
+
+	save := func(filename string, write func(w io.Writer) error) {
+		if filename == "" {
+			return
+		}
+		f, err := os.Create(filename)
+		if err == nil {
+			err = write(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	save(*profileOut, profiler.WritePprof)
+	save(*profileFolded, profiler.WriteFolded)
+	if *profileTop > 0 {
+		profiler.WriteTop(os.Stderr, *profileTop)
+	}
+	return runErr
+}
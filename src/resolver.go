@@ -2,15 +2,85 @@ package main
 
 import "fmt"
 
+// Local is the location of a resolved local variable: the slot of the
+// environment depth scopes out from where the variable is used.
+type Local struct {
+	depth int
+	slot  int
+}
+
+// Resolution is the result of resolving a program.
+type Resolution struct {
+	locals map[Expr]Local
+	sizes  map[Stmt]int         // number of slots of block and function scopes
+	tails  map[*StmtReturn]bool // returns of a call in tail position
+	scopes map[Stmt]*scope      // innermost scope of each statement, if recorded
+}
+
+type variable struct {
+	slot    int
+	defined bool
+	decl    *Declaration // only recorded for a listener
+}
+
+// DeclKind is the kind of a declared name.
+type DeclKind int
+
+const (
+	VarDecl DeclKind = iota
+	FunDecl
+	ClassDecl
+	TraitDecl
+	ParamDecl
+	MethodDecl
+)
+
+// Declaration is a name declared by a program.
+type Declaration struct {
+	Name    Token
+	Kind    DeclKind
+	Node    Stmt         // declaring statement, the function of a parameter
+	Depth   int          // number of enclosing scopes, 0 for globals
+	Shadows *Declaration // local of an enclosing scope with the same name
+}
+
+// ResolveListener is notified of the declarations and the variable uses
+// found while resolving, in source order. Methods are reported as
+// declarations but are not variables. Uses of globals are reported with a
+// nil declaration since globals are looked up by name at runtime.
+type ResolveListener interface {
+	Declare(decl *Declaration)
+	Use(name Token, decl *Declaration, assign bool)
+}
+
+// scope maps the variables declared in a block or function to their slots.
+// Globals are not tracked in any scope and are looked up by name at runtime.
+type scope struct {
+	vars map[string]*variable
+	size int
+
+	// recorded for debuggers, which find the variables of an environment
+	// by walking the scopes along the environment chain
+	names  []string // name of each slot
+	parent *scope   // nil for the scope enclosed by the globals
+}
+
 type Resolver struct {
-	locals map[Expr]int
-	scopes []map[string]bool
+	locals  map[Expr]Local
+	sizes   map[Stmt]int
+	tails   map[*StmtReturn]bool
+	scopes  []*scope
+	scopeOf map[Stmt]*scope // nil unless scopes are recorded
 
-	errs error
+	errs     error
+	problems []*LoxError
+	listener ResolveListener
 
 	// states
 	inclass        int
+	intrait        int
 	currentFuntion functionType
+	member         functionType // kind of the innermost class member
 }
 
 type functionType int
@@ -18,7 +88,9 @@ type functionType int
 const (
 	NoFuntion functionType = iota
 	NormalFunc
+	Method
 	Initializer
+	StaticMethod
 )
 
 var (
@@ -28,12 +100,33 @@ var (
 
 func NewResolver() *Resolver {
 	return &Resolver{
-		locals: make(map[Expr]int),
-		scopes: []map[string]bool{make(map[string]bool)},
+		locals: make(map[Expr]Local),
+		sizes:  make(map[Stmt]int),
+		tails:  make(map[*StmtReturn]bool),
+		scopes: make([]*scope, 0),
 	}
 }
 
+// SetListener sets the listener notified of declarations and uses.
+func (r *Resolver) SetListener(listener ResolveListener) {
+	r.listener = listener
+}
+
+// RecordScopes makes the resolution tell the scope of each statement with
+// the names of its slots, as needed to inspect environments.
+func (r *Resolver) RecordScopes() {
+	r.scopeOf = make(map[Stmt]*scope)
+}
+
+// Errors returns the errors reported so far.
+func (r *Resolver) Errors() []*LoxError {
+	return r.problems
+}
+
 func (r *Resolver) addError(err error) {
+	if e, ok := err.(*LoxError); ok {
+		r.problems = append(r.problems, e)
+	}
 	if r.errs == nil {
 		r.errs = err
 	} else {
@@ -41,11 +134,12 @@ func (r *Resolver) addError(err error) {
 	}
 }
 
-func (r *Resolver) Resolve(statements []Stmt) (map[Expr]int, error) {
+func (r *Resolver) Resolve(statements []Stmt) (resolution *Resolution, err error) {
 	defer func() {
 		e := recover()
 		if e != nil {
 			r.addError(e.(*LoxError))
+			resolution, err = nil, r.errs
 		}
 	}()
 
@@ -58,7 +152,36 @@ func (r *Resolver) Resolve(statements []Stmt) (map[Expr]int, error) {
 		}
 	}
 
-	return r.locals, r.errs
+	return &Resolution{locals: r.locals, sizes: r.sizes, tails: r.tails, scopes: r.scopeOf}, r.errs
+}
+
+// ResolveExpr resolves an expression used where the given names are in
+// scope, as a debugger evaluates it in a paused frame. scopes holds the
+// names of the slots of each enclosing scope, from the innermost.
+func (r *Resolver) ResolveExpr(expr Expr, scopes [][]string) (resolution *Resolution, err error) {
+	defer func() {
+		e := recover()
+		if e != nil {
+			r.addError(e.(*LoxError))
+			resolution, err = nil, r.errs
+		}
+	}()
+
+	r.currentFuntion = NormalFunc
+	for n := len(scopes) - 1; n >= 0; n-- {
+		r.beginScope()
+		for _, name := range scopes[n] {
+			if name == "super" {
+				r.inclass = 1
+			}
+			r.declare(name)
+			r.define(name)
+		}
+	}
+	if _, err := r.resolveExpr(expr); err != nil {
+		r.addError(err)
+	}
+	return &Resolution{locals: r.locals, sizes: r.sizes, tails: r.tails}, r.errs
 }
 
 func (r *Resolver) resolveExpr(expr Expr) (interface{}, error) {
@@ -66,44 +189,115 @@ func (r *Resolver) resolveExpr(expr Expr) (interface{}, error) {
 }
 
 func (r *Resolver) resolveStmt(stmt Stmt) (interface{}, error) {
+	if r.scopeOf != nil {
+		var s *scope // the statements of the script are at global scope
+		if len(r.scopes) > 0 {
+			s = r.scopes[len(r.scopes)-1]
+		}
+		r.scopeOf[stmt] = s
+	}
 	return stmt.Accept(r)
 }
 
 func (r *Resolver) beginScope() {
-	r.scopes = append(r.scopes, make(map[string]bool))
+	s := &scope{vars: make(map[string]*variable)}
+	if r.scopeOf != nil && len(r.scopes) > 0 {
+		s.parent = r.scopes[len(r.scopes)-1]
+	}
+	r.scopes = append(r.scopes, s)
 }
 
-func (r *Resolver) endScope() {
+// endScope leaves the innermost scope and returns its number of slots.
+func (r *Resolver) endScope() int {
+	size := r.scopes[len(r.scopes)-1].size
 	r.scopes = r.scopes[:len(r.scopes)-1]
+	return size
+}
+
+// declare allocates the next slot of the innermost scope to name, it returns
+// false if name was already declared in the scope. Every declaration takes a
+// new slot, as the interpreter defines one value per declaration.
+func (r *Resolver) declare(name string) bool {
+	if len(r.scopes) == 0 {
+		return true
+	}
+	s := r.scopes[len(r.scopes)-1]
+	_, found := s.vars[name]
+	s.vars[name] = &variable{slot: s.size}
+	s.size++
+	if r.scopeOf != nil {
+		s.names = append(s.names, name)
+	}
+	return !found
+}
+
+// declareName declares a name of the program, notifying the listener.
+func (r *Resolver) declareName(name Token, kind DeclKind, node Stmt) bool {
+	ok := r.declare(name.lexeme)
+	if r.listener != nil {
+		decl := &Declaration{Name: name, Kind: kind, Node: node, Depth: len(r.scopes)}
+		if len(r.scopes) > 0 {
+			r.scopes[len(r.scopes)-1].vars[name.lexeme].decl = decl
+		}
+		for n := len(r.scopes) - 2; n >= 0; n-- {
+			if v, ok := r.scopes[n].vars[name.lexeme]; ok {
+				decl.Shadows = v.decl
+				break
+			}
+		}
+		r.listener.Declare(decl)
+	}
+	return ok
 }
 
-func (r *Resolver) declare(name string) {
-	r.scopes[len(r.scopes)-1][name] = false
+// declareMethod notifies the listener of a method, which is not a variable.
+func (r *Resolver) declareMethod(method *StmtFun) {
+	if r.listener != nil {
+		r.listener.Declare(&Declaration{Name: method.Name, Kind: MethodDecl, Node: method, Depth: len(r.scopes)})
+	}
 }
 
 func (r *Resolver) define(name string) {
-	if _, ok := r.scopes[len(r.scopes)-1][name]; !ok {
+	if len(r.scopes) == 0 {
+		return
+	}
+	v, ok := r.scopes[len(r.scopes)-1].vars[name]
+	if !ok {
 		panic("programming error")
 	}
-	r.scopes[len(r.scopes)-1][name] = true
+	v.defined = true
 }
 
-func (r *Resolver) resolveLocal(expr Expr, nameTK Token, mustResolve bool) bool {
-	name := nameTK.Value().(string)
+// resolveLocal records the location of the local variable nameTK refers to,
+// it returns nil if nameTK is a global.
+func (r *Resolver) resolveLocal(expr Expr, nameTK Token) *variable {
+	name := nameTK.lexeme
 	distance := -1
 	for i := len(r.scopes) - 1; i >= 0; i-- {
 		distance++
-		init, ok := r.scopes[i][name]
+		v, ok := r.scopes[i].vars[name]
 		if !ok {
 			continue
 		}
-		if !init {
-			panic(NewLoxError(ResolveError, nameTK, "resolver: uninitialized variable"))
+		if !v.defined {
+			panic(NewLoxError(ResolveError, nameTK, "Can't read local variable in its own initializer."))
 		}
-		r.locals[expr] = distance
-		return true
+		r.locals[expr] = Local{depth: distance, slot: v.slot}
+		return v
 	}
-	return !mustResolve
+	return nil
+}
+
+// use notifies the listener of the use of a variable.
+func (r *Resolver) use(name Token, v *variable, assign bool) {
+	if r.listener == nil {
+		return
+	}
+	var decl *Declaration
+	if v != nil {
+		decl = v.decl
+	}
+	r.listener.Use(name, decl, assign)
 }
 
 func (r *Resolver) VisitLiteral(*ExprLiteral) (interface{}, error) {
@@ -111,12 +305,12 @@ func (r *Resolver) VisitLiteral(*ExprLiteral) (interface{}, error) {
 }
 
 func (r *Resolver) VisitVariable(expr *ExprVariable) (interface{}, error) {
-	r.resolveLocal(expr, expr.Name, false /* mustResolve */)
+	r.use(expr.Name, r.resolveLocal(expr, expr.Name), false)
 	return nil, nil
 }
 
 func (r *Resolver) VisitAssign(expr *ExprAssign) (interface{}, error) {
-	r.resolveLocal(expr, expr.Name, false)
+	r.use(expr.Name, r.resolveLocal(expr, expr.Name), true)
 	return r.resolveExpr(expr.Value)
 }
 
@@ -178,20 +372,32 @@ func (r *Resolver) VisitSet(expr *ExprSet) (interface{}, error) {
 }
 
 func (r *Resolver) VisitThis(expr *ExprThis) (interface{}, error) {
-	if !r.resolveLocal(expr, expr.Keyword, true) {
+	if r.resolveLocal(expr, expr.Keyword) == nil {
 		panic(NewLoxError(ResolveError, expr.Keyword, "Can't use 'this' out of class"))
 	}
 	return nil, nil
 }
 
 func (r *Resolver) VisitSuper(expr *ExprSuper) (interface{}, error) {
+	if r.intrait > 0 {
+		r.addError(
+			NewLoxError(ResolveError, expr.Keyword, "Can't use 'super' in a trait."),
+		)
+		return nil, nil
+	}
 	if r.inclass <= 0 {
 		r.addError(
 			NewLoxError(ResolveError, expr.Keyword, "Can't use 'super' outside of a class."),
 		)
 		return nil, nil
 	}
-	if !r.resolveLocal(expr, expr.Keyword, true) {
+	if r.member == StaticMethod {
+		r.addError(
+			NewLoxError(ResolveError, expr.Keyword, "Can't use 'super' in a static method."),
+		)
+		return nil, nil
+	}
+	if r.resolveLocal(expr, expr.Keyword) == nil {
 		r.addError(
 			NewLoxError(ResolveError, expr.Keyword, "Can't use 'super' in a class with no superclass."),
 		)
@@ -199,6 +405,17 @@ func (r *Resolver) VisitSuper(expr *ExprSuper) (interface{}, error) {
 	return nil, nil
 }
 
+func (r *Resolver) VisitIndex(expr *ExprIndex) (interface{}, error) {
+	if _, err := r.resolveExpr(expr.Object); err != nil {
+		return nil, err
+	}
+	return r.resolveExpr(expr.Index)
+}
+
+func (r *Resolver) VisitSpread(expr *ExprSpread) (interface{}, error) {
+	return r.resolveExpr(expr.Expression)
+}
+
 func (r *Resolver) VisitExpression(stmt *StmtExpression) (interface{}, error) {
 	return r.resolveExpr(stmt.Expression)
 }
@@ -208,8 +425,10 @@ func (r *Resolver) VisitPrint(stmt *StmtPrint) (interface{}, error) {
 }
 
 func (r *Resolver) VisitVar(stmt *StmtVar) (interface{}, error) {
-	name := stmt.Name.Value().(string)
-	r.declare(name)
+	name := stmt.Name.lexeme
+	if !r.declareName(stmt.Name, VarDecl, stmt) {
+		r.addError(NewLoxError(ResolveError, stmt.Name, "Already a variable with this name in this scope."))
+	}
 
 	if stmt.Initializer != nil {
 		if _, err := r.resolveExpr(stmt.Initializer); err != nil {
@@ -228,7 +447,7 @@ func (r *Resolver) VisitBlock(stmt *StmtBlock) (interface{}, error) {
 			return nil, err
 		}
 	}
-	r.endScope()
+	r.sizes[stmt] = r.endScope()
 	return nil, nil
 }
 
@@ -255,35 +474,60 @@ func (r *Resolver) VisitWhile(stmt *StmtWhile) (interface{}, error) {
 func (r *Resolver) resolveFunction(stmt *StmtFun, functionT functionType) (interface{}, error) {
 	preFuntionT := r.currentFuntion
 	r.currentFuntion = functionT
+	// functions nested in a class member are part of the member
+	preMember := r.member
+	if functionT != NormalFunc {
+		r.member = functionT
+	}
 	defer func() {
 		r.currentFuntion = preFuntionT
+		r.member = preMember
 	}()
 
-	r.declare(stmt.Name)
-	r.define(stmt.Name)
-
 	r.beginScope()
-	for _, param := range stmt.Params {
-		r.declare(param)
-		r.define(param)
+	// this takes the first slot of the frame of a method
+	if functionT == Method || functionT == Initializer {
+		r.declare("this")
+		r.define("this")
+	}
+	for n, param := range stmt.Params {
+		// default values may refer to the preceding parameters
+		if value := stmt.Defaults[n]; value != nil {
+			if _, err := r.resolveExpr(value); err != nil {
+				return nil, err
+			}
+		}
+		r.declareName(param, ParamDecl, stmt)
+		r.define(param.lexeme)
+	}
+	if stmt.Rest != nil {
+		r.declareName(*stmt.Rest, ParamDecl, stmt)
+		r.define(stmt.Rest.lexeme)
 	}
 	for _, statement := range stmt.Body {
 		if _, err := r.resolveStmt(statement); err != nil {
 			return nil, err
 		}
 	}
-	r.endScope()
+	r.sizes[stmt] = r.endScope()
 	return nil, nil
 }
 
 func (r *Resolver) VisitFun(stmt *StmtFun) (interface{}, error) {
+	r.declareName(stmt.Name, FunDecl, stmt)
+	r.define(stmt.Name.lexeme)
 	return r.resolveFunction(stmt, NormalFunc)
 }
 
 func (r *Resolver) VisitReturn(stmt *StmtReturn) (interface{}, error) {
 	if stmt.Value != nil {
 		if r.currentFuntion == Initializer {
-			r.addError(NewLoxError(ResolveError, stmt.Keyword, "Can't return a value from an initializer."))
+			r.addError(NewLoxError(ResolveError, stmt.Keyword, "Can't return a value from an initializer.").spanning(stmt.Value.Span()))
+		}
+		// the frame of the function can be reused by a call in tail
+		// position, as nothing is left to do after it returns
+		if _, ok := stmt.Value.(*ExprCall); ok && r.currentFuntion != NoFuntion {
+			r.tails[stmt] = true
 		}
 		return r.resolveExpr(stmt.Value)
 	}
@@ -301,41 +545,108 @@ func (r *Resolver) endClass() {
 func (r *Resolver) VisitClass(stmt *StmtClass) (interface{}, error) {
 	r.enterClass()
 
+	// methods of a class nested in a trait method may use super
+	preTrait := r.intrait
+	r.intrait = 0
+	defer func() {
+		r.intrait = preTrait
+	}()
+
 	if stmt.Superclass != nil {
-		if stmt.Superclass.Name.lexeme == stmt.Name {
+		if stmt.Superclass.Name.lexeme == stmt.Name.lexeme {
 			r.addError(NewLoxError(
 				ResolveError, stmt.Superclass.Name, "A class can't inherit from itself.",
-			))
+			).spanning(Span{Start: stmt.Name.start(), End: stmt.Superclass.Span().End}))
 		}
 		if _, err := r.resolveExpr(stmt.Superclass); err != nil {
 			return nil, err
 		}
 	}
 
-	r.declare(stmt.Name)
-	r.define(stmt.Name)
+	used := make(map[string]bool)
+	for _, trait := range stmt.Traits {
+		name := trait.Name.lexeme
+		if name == stmt.Name.lexeme {
+			r.addError(NewLoxError(
+				ResolveError, trait.Name, "A class can't use itself as a trait.",
+			))
+		}
+		if used[name] {
+			r.addError(NewLoxError(
+				ResolveError, trait.Name, "A trait can't be used more than once.",
+			))
+		}
+		used[name] = true
+		if _, err := r.resolveExpr(trait); err != nil {
+			return nil, err
+		}
+	}
 
-	r.beginScope()
-	r.declare("this")
-	r.define("this")
+	r.declareName(stmt.Name, ClassDecl, stmt)
+	r.define(stmt.Name.lexeme)
 
 	if stmt.Superclass != nil {
+		r.beginScope()
 		r.declare("super")
 		r.define("super")
 	}
 
-	r.beginScope()
 	for _, method := range stmt.Methods {
-		if method.Name == "init" {
+		r.declareMethod(method)
+		if method.Name.lexeme == "init" {
 			r.resolveFunction(method, Initializer)
 		} else {
-			r.resolveFunction(method, NormalFunc)
+			r.resolveFunction(method, Method)
 		}
 	}
-	r.endScope()
+	for _, getter := range stmt.Getters {
+		r.declareMethod(getter)
+		r.resolveFunction(getter, Method)
+	}
+	for _, setter := range stmt.Setters {
+		r.declareMethod(setter)
+		r.resolveFunction(setter, Method)
+	}
+
+	if stmt.Superclass != nil {
+		r.endScope()
+	}
+
+	// static methods see this as the class object and have no super
+	r.beginScope()
+	r.declare("this")
+	r.define("this")
+
+	for _, method := range stmt.StaticMethods {
+		r.declareMethod(method)
+		r.resolveFunction(method, StaticMethod)
+	}
 
 	r.endScope()
 
 	r.endClass()
 	return nil, nil
 }
+
+func (r *Resolver) VisitTrait(stmt *StmtTrait) (interface{}, error) {
+	r.intrait++
+	preClass := r.inclass
+	r.inclass = 0
+	defer func() {
+		r.intrait--
+		r.inclass = preClass
+	}()
+
+	r.declareName(stmt.Name, TraitDecl, stmt)
+	r.define(stmt.Name.lexeme)
+
+	for _, method := range stmt.Methods {
+		r.declareMethod(method)
+		if method.Name.lexeme == "init" {
+			r.resolveFunction(method, Initializer)
+		} else {
+			r.resolveFunction(method, Method)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability names a group of natives which touch the host.
+type Capability string
+
+const (
+	CapNone Capability = ""     // pure natives, always allowed
+	CapTime Capability = "time" // clock, sleep
+	CapIO   Capability = "io"   // reading and writing files
+	CapOS   Capability = "os"   // environment, processes
+)
+
+var capabilities = []Capability{CapTime, CapIO, CapOS}
+
+// Sandbox restricts the natives a program may call. Natives of a
+// capability group are only callable if the group has been granted.
+type Sandbox struct {
+	granted map[Capability]bool
+}
+
+func NewSandbox(grants ...Capability) *Sandbox {
+	s := &Sandbox{granted: make(map[Capability]bool)}
+	for _, c := range grants {
+		s.Grant(c)
+	}
+	return s
+}
+
+// ParseCapabilities parses a comma separated list of capability names.
+func ParseCapabilities(s string) ([]Capability, error) {
+	var caps []Capability
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := lookupCapability(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+func lookupCapability(name string) (Capability, bool) {
+	for _, c := range capabilities {
+		if string(c) == name {
+			return c, true
+		}
+	}
+	return CapNone, false
+}
+
+func (s *Sandbox) Grant(c Capability) {
+	s.granted[c] = true
+}
+
+// Allows reports whether natives of capability c can be called, a nil
+// sandbox allows everything.
+func (s *Sandbox) Allows(c Capability) bool {
+	if s == nil || c == CapNone {
+		return true
+	}
+	return s.granted[c]
+}
+
+// SetSandbox restricts the natives callable by the interpreter, nil
+// disables the sandbox.
+func (i *Interpreter) SetSandbox(sandbox *Sandbox) {
+	i.sandbox = sandbox
+}
+
+// checkCapability returns a runtime error if the sandbox denies calling fn.
+func (i *Interpreter) checkCapability(fn *BuildinFun, token Token) error {
+	if i.sandbox.Allows(fn.capability) {
+		return nil
+	}
+	return NewLoxError(RuntimeError, token, fmt.Sprintf(
+		"Native function '%s' requires the '%s' capability.", fn.name, fn.capability))
+}
@@ -17,8 +17,11 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
 	DOT
+	ELLIPSIS
 	MINUS
 	PLUS
 	SEMICOLON
@@ -54,9 +57,11 @@ const (
 	RETURN
 	SUPER
 	THIS
+	TRAIT
 	TRUE
 	VAR
 	WHILE
+	WITH
 )
 
 func (t TokenType) String() string {
@@ -70,10 +75,16 @@ func (t TokenType) String() string {
 		return "LEFT_BRACE"
 	case RIGHT_BRACE:
 		return "RIGHT_BRACE"
+	case LEFT_BRACKET:
+		return "LEFT_BRACKET"
+	case RIGHT_BRACKET:
+		return "RIGHT_BRACKET"
 	case COMMA:
 		return "COMMA"
 	case DOT:
 		return "DOT"
+	case ELLIPSIS:
+		return "ELLIPSIS"
 	case MINUS:
 		return "MINUS"
 	case PLUS:
@@ -138,12 +149,16 @@ func (t TokenType) String() string {
 		return "SUPER"
 	case THIS:
 		return "THIS"
+	case TRAIT:
+		return "TRAIT"
 	case TRUE:
 		return "TRUE"
 	case VAR:
 		return "VAR"
 	case WHILE:
 		return "WHILE"
+	case WITH:
+		return "WITH"
 
 	case EOF:
 		return "EOF"
@@ -158,8 +173,9 @@ type Token struct {
 	lexeme string
 	lexval interface{}
 	// token position in source code
-	row int
-	col int
+	row    int
+	col    int
+	offset int // of the first byte
 }
 
 func (token Token) Type() TokenType {
@@ -206,8 +222,30 @@ type Scanner struct {
 	srow    int // start row
 	scol    int // start col
 	scanned bool
-	errors  []error
+	errors  []*ScanError
 	tokens  []Token
+	trivia  []Comment
+	strings internTable
+}
+
+// Comment is a comment of the source, which the scanner keeps apart from the
+// tokens.
+type Comment struct {
+	Text   string // including the leading //
+	Row    int
+	Offset int
+}
+
+// ScanError is a lexical error at a position of the source.
+type ScanError struct {
+	Row    int
+	Col    int
+	Offset int
+	Msg    string
+}
+
+func (e *ScanError) Error() string {
+	return logger.NewError(e.Row, e.Col, e.Msg).Error()
 }
 
 func NewScanner(src string) *Scanner {
@@ -220,13 +258,14 @@ func NewScanner(src string) *Scanner {
 		srow:    1,
 		scol:    1,
 		scanned: false,
-		errors:  make([]error, 0),
+		errors:  make([]*ScanError, 0),
 		tokens:  make([]Token, 0),
+		strings: make(internTable),
 	}
 }
 
 func (s *Scanner) Tokens() ([]Token, error) {
-	s.scan()
+	s.Scan()
 	if s.hasError() {
 		for _, err := range s.errors {
 			logger.EPrintf("%s", err)
@@ -239,6 +278,22 @@ func (s *Scanner) Tokens() ([]Token, error) {
 	return s.tokens, nil
 }
 
+// Comments returns the comments of the source, in order.
+func (s *Scanner) Comments() []Comment {
+	s.Scan()
+	return s.trivia
+}
+
+// Scan returns the tokens of the source and the errors found, without
+// reporting them.
+func (s *Scanner) Scan() ([]Token, []*ScanError) {
+	if !s.scanned {
+		s.scan()
+		s.scanned = true
+	}
+	return s.tokens, s.errors
+}
+
 func (s *Scanner) scan() {
 	for !s.atEnd() {
 		s.scanToken()
@@ -274,6 +329,7 @@ func (s *Scanner) addToken(typ TokenType, val interface{}) {
 		lexval: val,
 		row:    s.srow,
 		col:    s.scol,
+		offset: s.start,
 	})
 }
 
@@ -310,7 +366,7 @@ func (s *Scanner) lookahead() byte {
 }
 
 func (s *Scanner) lexeme() string {
-	return string(s.src[s.start:s.current])
+	return s.strings.intern(s.src[s.start:s.current])
 }
 
 func (s *Scanner) number() {
@@ -337,25 +393,29 @@ func (s *Scanner) string() {
 	// row and col for report errors
 	row, col := s.row, s.col-1
 
-	var str string
 	for !s.atEnd() {
 		c := s.advance()
 		if c == '"' {
-			s.addToken(STRING, str)
+			// the literal lies between the quotes
+			s.addToken(STRING, s.strings.intern(s.src[s.start+1:s.current-1]))
 			return
 		}
-		str += string(c)
 	}
 
-	s.errors = append(s.errors, logger.NewError(
-		row, col, "Unterminated string",
-	))
+	s.errors = append(s.errors, &ScanError{
+		Row: row, Col: col, Offset: s.start, Msg: "Unterminated string",
+	})
 }
 
 func (s *Scanner) comment() {
 	for !s.atEnd() && s.peek() != '\n' {
 		s.advance()
 	}
+	s.trivia = append(s.trivia, Comment{
+		Text:   string(s.src[s.start:s.current]),
+		Row:    s.srow,
+		Offset: s.start,
+	})
 }
 
 func (s *Scanner) other(b byte) {
@@ -369,10 +429,20 @@ func (s *Scanner) other(b byte) {
 		s.addToken(LEFT_BRACE, nil)
 	case '}':
 		s.addToken(RIGHT_BRACE, nil)
+	case '[':
+		s.addToken(LEFT_BRACKET, nil)
+	case ']':
+		s.addToken(RIGHT_BRACKET, nil)
 	case ',':
 		s.addToken(COMMA, nil)
 	case '.':
-		s.addToken(DOT, nil)
+		if s.peek() == '.' && s.lookahead() == '.' {
+			s.advance()
+			s.advance()
+			s.addToken(ELLIPSIS, nil)
+		} else {
+			s.addToken(DOT, nil)
+		}
 	case '-':
 		s.addToken(MINUS, nil)
 	case '+':
@@ -395,8 +465,9 @@ func (s *Scanner) other(b byte) {
 		if s.peek() == '=' {
 			s.advance()
 			s.addToken(BANG_EQUAL, nil)
+		} else {
+			s.addToken(BANG, nil)
 		}
-		s.addToken(BANG, nil)
 	case '=':
 		if s.peek() == '=' {
 			s.advance()
@@ -425,9 +496,9 @@ func (s *Scanner) other(b byte) {
 
 	default:
 		// NB: is safe to set col = s.col-1 here?
-		s.errors = append(s.errors, logger.NewError(
-			s.row, s.col-1, "Unknown character "+string(b),
-		))
+		s.errors = append(s.errors, &ScanError{
+			Row: s.row, Col: s.col - 1, Offset: s.current - 1, Msg: "Unknown character " + string(b),
+		})
 	}
 }
 
@@ -445,9 +516,11 @@ var scannerKeywords = map[string]TokenType{
 	"return": RETURN,
 	"super":  SUPER,
 	"this":   THIS,
+	"trait":  TRAIT,
 	"true":   TRUE,
 	"var":    VAR,
 	"while":  WHILE,
+	"with":   WITH,
 }
 
 func (s *Scanner) keywordOrIdent() {
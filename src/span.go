@@ -0,0 +1,87 @@
+package main
+
+// Position is a position in a source: its line and column, from 1, and its
+// byte offset.
+type Position struct {
+	Row    int `json:"row"`
+	Col    int `json:"col"`
+	Offset int `json:"offset"`
+}
+
+// Span is the source of a node, from the start of its first token to the
+// end of its last one, End being the position after it. Nodes made up by
+// the parser, such as the missing condition of a for loop, have an empty
+// span where they would be.
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// nodeSpan is embedded in every node by astgen to record its span.
+type nodeSpan struct {
+	span Span
+}
+
+func (n *nodeSpan) Span() Span {
+	return n.span
+}
+
+func (n *nodeSpan) SetSpan(span Span) {
+	n.span = span
+}
+
+// Len returns the number of bytes of the span.
+func (s Span) Len() int {
+	return s.End.Offset - s.Start.Offset
+}
+
+// IsZero tells if the span is unknown, as for nodes built by hand.
+func (s Span) IsZero() bool {
+	return s == Span{}
+}
+
+// start returns the position of the first byte of the token.
+func (token Token) start() Position {
+	return Position{Row: token.row, Col: token.col, Offset: token.offset}
+}
+
+// end returns the position after the last byte of the token, counting
+// columns as the scanner does.
+func (token Token) end() Position {
+	pos := token.start()
+	for n := 0; n < len(token.lexeme); n++ {
+		switch token.lexeme[n] {
+		case '\n':
+			pos.Row++
+			pos.Col = 1
+		case '\t':
+			pos.Col = pos.Col + (8 - pos.Col/8)
+		default:
+			pos.Col++
+		}
+	}
+	pos.Offset += len(token.lexeme)
+	return pos
+}
+
+// tokenSpan returns the span of a token.
+func tokenSpan(token Token) Span {
+	return Span{Start: token.start(), End: token.end()}
+}
+
+// spanOf returns the span of a node, or of a token, the zero span for nil.
+func spanOf(node interface{}) Span {
+	switch n := node.(type) {
+	case Expr:
+		if n != nil {
+			return n.Span()
+		}
+	case Stmt:
+		if n != nil {
+			return n.Span()
+		}
+	case Token:
+		return tokenSpan(n)
+	}
+	return Span{}
+}
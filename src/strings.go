@@ -0,0 +1,76 @@
+package main
+
+import "unsafe"
+
+// internTable holds the strings of a source, so that equal identifiers and
+// literals share their bytes. Comparing two strings with the same bytes
+// returns as soon as their pointers are found equal. A table lives as long
+// as the scanner and the tokens of its source.
+type internTable map[string]string
+
+// intern returns the shared copy of the string of b.
+func (t internTable) intern(b []byte) string {
+	if s, ok := t[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	t[s] = s
+	return s
+}
+
+// concatBuffer makes repeated concatenations to the same string, such as
+// s = s + x in a loop, take amortized linear time. It holds the bytes of the
+// last concatenation result with room to grow: a string is appended in place
+// when its left operand is that last result.
+//
+// The bytes past the last result are not part of any string, so strings
+// sharing the buffer are never changed.
+type concatBuffer struct {
+	buf []byte
+}
+
+// concat returns a + b and the number of bytes allocated for it.
+func (c *concatBuffer) concat(a, b string) (string, int) {
+	if len(b) == 0 {
+		return a, 0
+	}
+	if len(a) == 0 {
+		return b, 0
+	}
+
+	allocated := 0
+	if !c.isLast(a) || cap(c.buf)-len(c.buf) < len(b) {
+		if c.isLast(a) {
+			// grow the buffer of the last result geometrically
+			allocated = 2 * (len(a) + len(b))
+		} else {
+			allocated = len(a) + len(b)
+		}
+		buf := make([]byte, len(a), allocated)
+		copy(buf, a)
+		c.buf = buf
+	}
+	c.buf = append(c.buf, b...)
+	return bytesToString(c.buf), allocated
+}
+
+// isLast reports whether s is the last concatenation result.
+func (c *concatBuffer) isLast(s string) bool {
+	return len(c.buf) > 0 && len(s) == len(c.buf) &&
+		stringData(s) == unsafe.Pointer(&c.buf[0])
+}
+
+// stringHeader is the runtime representation of a string.
+type stringHeader struct {
+	data unsafe.Pointer
+	len  int
+}
+
+func stringData(s string) unsafe.Pointer {
+	return (*stringHeader)(unsafe.Pointer(&s)).data
+}
+
+// bytesToString returns a string sharing the bytes of b.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tracer is the hooks of the -trace mode, which prints the calls of a
+// script, indented by depth, with their arguments and returned values.
+type Tracer struct {
+	w      io.Writer
+	frames []*traceFrame // innermost last
+	tail   int           // line of the tail call about to be made, or 0
+}
+
+type traceFrame struct {
+	lines []int // lines of the statements being executed, innermost last
+	last  int   // line of the last statement run
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w, frames: []*traceFrame{{}}}
+}
+
+var _ Hooks = &Tracer{}
+
+func (t *Tracer) frame() *traceFrame {
+	return t.frames[len(t.frames)-1]
+}
+
+// line returns the line of the innermost statement being executed.
+func (t *Tracer) line() int {
+	frame := t.frame()
+	for n := len(frame.lines) - 1; n >= 0; n-- {
+		if frame.lines[n] > 0 {
+			return frame.lines[n]
+		}
+	}
+	return frame.last
+}
+
+// maxTraceIndent is the depth from which calls are not indented further
+// but prefixed by their depth.
+const maxTraceIndent = 32
+
+func (t *Tracer) print(line int, format string, a ...interface{}) {
+	depth := len(t.frames) - 1
+	indent := strings.Repeat("  ", depth)
+	if depth > maxTraceIndent {
+		indent = strings.Repeat("  ", maxTraceIndent) + fmt.Sprintf("[%d] ", depth)
+	}
+	fmt.Fprintf(t.w, "%5d | %s%s\n", line, indent, fmt.Sprintf(format, a...))
+}
+
+func (t *Tracer) Statement(stmt Stmt) error {
+	line := 0
+	if token, ok := nodeToken(stmt); ok {
+		line = token.row
+	}
+	frame := t.frame()
+	frame.lines = append(frame.lines, line)
+	if line > 0 {
+		frame.last = line
+	}
+	return nil
+}
+
+func (t *Tracer) StatementDone(stmt Stmt) {
+	frame := t.frame()
+	frame.lines = frame.lines[:len(frame.lines)-1]
+}
+
+func (t *Tracer) Call(fn *LoxFunction, args []interface{}) {
+	strs := make([]string, len(args))
+	for n, arg := range args {
+		strs[n] = debugString(arg)
+	}
+	line := t.line()
+	if t.tail > 0 {
+		line, t.tail = t.tail, 0
+	}
+	t.print(line, "call %s(%s)", fn.Name(), strings.Join(strs, ", "))
+	t.frames = append(t.frames, &traceFrame{})
+}
+
+func (t *Tracer) Return(fn *LoxFunction, value interface{}, err error) {
+	line := t.line()
+	t.frames = t.frames[:len(t.frames)-1]
+	switch {
+	case err != nil:
+		t.print(line, "%s failed", fn.Name())
+	case value == TailCalled:
+		t.print(line, "%s tail calls", fn.Name())
+		t.tail = line
+	default:
+		t.print(line, "%s returned %s", fn.Name(), debugString(value))
+	}
+}
+
+func (t *Tracer) Assign(name Token, value interface{}) {}
+
+func (t *Tracer) Error(err error) {
+	line := t.line()
+	if e, ok := err.(*LoxError); ok {
+		line = e.tk.row
+	}
+	t.print(line, "error: %s", debugError(err))
+}
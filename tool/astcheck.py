@@ -0,0 +1,96 @@
+#! /usr/bin/python3
+
+# Checks the syntax trees: usage astcheck.py golox test
+#
+# src/ast.go must be what astgen generates, formatted by gofmt. For each
+# file of the corpus which parses, the JSON of its tree loaded back must
+# give the same JSON, and the loaded tree must print what the source does.
+
+import os
+import subprocess
+import sys
+import tempfile
+
+Total = 0
+Skipped = 0
+Failed = []
+
+def checkAstgen():
+    root = os.path.dirname(os.path.dirname(os.path.abspath(__file__)))
+    gen = subprocess.run(["go", "run", "tool/astgen.go"], cwd=root,
+                         stdout=subprocess.PIPE, check=True)
+    fmt = subprocess.run(["gofmt"], input=gen.stdout, stdout=subprocess.PIPE, check=True)
+    with open(os.path.join(root, "src", "ast.go"), "rb") as f:
+        if f.read() != fmt.stdout:
+            print("=== FAIL: src/ast.go is not what astgen generates, run ./astgen")
+            return False
+    return True
+
+def golox(*args, cwd=None):
+    p = subprocess.run([sys.argv[1]] + list(args), cwd=cwd,
+                       stdout=subprocess.PIPE, stderr=subprocess.DEVNULL)
+    return p.returncode, p.stdout
+
+def run(*args, cwd=None):
+    """Returns the exit code of golox, its output and the first line of its
+    errors, which is their message: the lines of the source shown under it
+    are not known to a loaded tree."""
+    p = subprocess.run([sys.argv[1]] + list(args), cwd=cwd,
+                       stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+    return p.returncode, p.stdout, p.stderr.split(b"\n")[0]
+
+def check(filename, tmpdir):
+    code, tree = golox("ast", "-json", filename)
+    if code != 0:
+        return None
+
+    tmp = os.path.join(tmpdir, "tree.json")
+    with open(tmp, "wb") as f:
+        f.write(tree)
+    if golox("ast", "-load", "-json", tmp) != (0, tree):
+        return "the loaded tree differs"
+    # tests with a NAME.args file are run with their own arguments and input
+    if "benchmark" not in filename and not os.path.exists(filename[:-len(".lox")] + ".args"):
+        cwd = os.path.dirname(filename)
+        direct = run("ast", "-run", os.path.basename(filename), cwd=cwd)
+        loaded = run("ast", "-load", "-run", tmp, cwd=cwd)
+        # the output of some scripts, such as printed addresses, changes
+        # from run to run
+        again = run("ast", "-run", os.path.basename(filename), cwd=cwd)
+        if direct == again and direct != loaded:
+            return "the loaded tree prints something else"
+    return ""
+
+def checkFile(filename, tmpdir):
+    if not filename.endswith(".lox"):
+        return
+
+    global Total, Skipped, Failed
+    Total += 1
+    problem = check(filename, tmpdir)
+    if problem is None:
+        Skipped += 1
+    elif problem:
+        Failed.append(filename)
+        print("=== FAIL: %s: %s" % (filename, problem))
+
+def checkDir(dirname, tmpdir):
+    root, subdirs, files = next(os.walk(dirname))
+    for f in sorted(files):
+        checkFile(os.path.join(root, f), tmpdir)
+    for d in sorted(subdirs):
+        checkDir(os.path.join(root, d), tmpdir)
+
+def main():
+    ok = checkAstgen()
+    root = sys.argv[2]
+    with tempfile.TemporaryDirectory() as tmpdir:
+        if os.path.isdir(root):
+            checkDir(root, tmpdir)
+        else:
+            checkFile(root, tmpdir)
+    print("=== Total: %d Skipped: %d Failed: %d" % (Total, Skipped, len(Failed)))
+    if Failed or not ok:
+        sys.exit(1)
+
+main()
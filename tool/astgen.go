@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 var writer io.Writer
@@ -24,13 +25,83 @@ type Type struct {
 
 const head = `package main
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 `
 
+// decoders are the suffixes of the functions decoding each type of field
+// from JSON, see src/astjson.go.
+var decoders = map[string]string{
+	"interface{}":     "Value",
+	"Token":           "Token",
+	"*Token":          "TokenPtr",
+	"[]Token":         "TokenList",
+	"Expr":            "Expr",
+	"[]Expr":          "ExprList",
+	"Stmt":            "Stmt",
+	"[]Stmt":          "StmtList",
+	"*ExprVariable":   "Variable",
+	"[]*ExprVariable": "VariableList",
+	"[]*StmtFun":      "FunList",
+}
+
+// jsonName returns the name of a field in JSON.
+func jsonName(fname string) string {
+	return strings.ToLower(fname[:1]) + fname[1:]
+}
+
+// defineCodecs writes the JSON encoding and decoding of the nodes.
+func defineCodecs(baseName string, types []Type) {
+	for i := range types {
+		typeName := baseName + types[i].typename
+		writef("func(node *%s) MarshalJSON() ([]byte, error) {\n", typeName)
+		writef("\tobj := newJSONNode(%q, node.Span())\n", typeName)
+		for _, field := range types[i].fields {
+			writef("\tobj.add(%q, node.%s)\n", jsonName(field.fname), field.fname)
+		}
+		writef("\treturn obj.MarshalJSON()\n")
+		writef("}\n\n")
+	}
+
+	writef("func decode%s(data json.RawMessage) (%s, error) {\n", baseName, baseName)
+	writef("\tkind, span, fields, err := decodeJSONNode(data)\n")
+	writef("\tif err != nil || kind == \"\" {\n")
+	writef("\t\treturn nil, err\n")
+	writef("\t}\n")
+	writef("\tswitch kind {\n")
+	for i := range types {
+		typeName := baseName + types[i].typename
+		writef("\tcase %q:\n", typeName)
+		writef("\t\tnode := &%s{}\n", typeName)
+		writef("\t\tnode.SetSpan(span)\n")
+		for _, field := range types[i].fields {
+			decoder, ok := decoders[field.tname]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "astgen: no JSON decoder of %s for %s.%s\n", field.tname, typeName, field.fname)
+				os.Exit(1)
+			}
+			name := jsonName(field.fname)
+			writef("\t\tif node.%s, err = decode%s(fields[%q]); err != nil {\n", field.fname, decoder, name)
+			writef("\t\t\treturn nil, fmt.Errorf(\"%s.%s: %%s\", err)\n", typeName, name)
+			writef("\t\t}\n")
+		}
+		writef("\t\treturn node, nil\n")
+	}
+	writef("\t}\n")
+	writef("\treturn nil, fmt.Errorf(\"unknown %s kind %%q\", kind)\n", strings.ToLower(baseName))
+	writef("}\n\n")
+}
+
 func defineAST(baseName string, types []Type) {
 	// write common interface
 	writef("type %s interface {\n", baseName)
 	writef("\tType() %sType\n", baseName)
 	writef("\tAccept(%sVisitor) (interface{}, error)\n", baseName)
+	writef("\tSpan() Span\n")
+	writef("\tSetSpan(Span)\n")
 	writef("}\n\n")
 
 	// write type enum and add acceptors
@@ -56,6 +127,7 @@ func defineAST(baseName string, types []Type) {
 	for i := range types {
 		typeName := baseName + types[i].typename
 		writef("type %s struct{\n", typeName)
+		writef("\tnodeSpan\n")
 		for _, field := range types[i].fields {
 			writef("\t%s %s\n", field.fname, field.tname)
 		}
@@ -175,7 +247,25 @@ func main() {
 		},
 	})
 
+	types = append(types, Type{
+		typename: "Index",
+		fields: []Field{
+			{"Expr", "Object"},
+			{"Token", "Bracket"},
+			{"Expr", "Index"},
+		},
+	})
+
+	types = append(types, Type{
+		typename: "Spread",
+		fields: []Field{
+			{"Token", "Ellipsis"},
+			{"Expr", "Expression"},
+		},
+	})
+
 	defineAST("Expr", types)
+	defineCodecs("Expr", types)
 
 	types = []Type{}
 
@@ -189,6 +279,7 @@ func main() {
 	types = append(types, Type{
 		typename: "Print",
 		fields: []Field{
+			{"Token", "Keyword"},
 			{"Expr", "Expression"},
 		},
 	})
@@ -220,6 +311,7 @@ func main() {
 	types = append(types, Type{
 		typename: "While",
 		fields: []Field{
+			{"Token", "Keyword"},
 			{"Expr", "Cond"},
 			{"Stmt", "Body"},
 		},
@@ -228,8 +320,10 @@ func main() {
 	types = append(types, Type{
 		typename: "Fun",
 		fields: []Field{
-			{"string", "Name"},
-			{"[]string", "Params"},
+			{"Token", "Name"},
+			{"[]Token", "Params"},
+			{"[]Expr", "Defaults"},
+			{"*Token", "Rest"},
 			{"[]Stmt", "Body"},
 		},
 	})
@@ -245,11 +339,24 @@ func main() {
 	types = append(types, Type{
 		typename: "Class",
 		fields: []Field{
-			{"string", "Name"},
+			{"Token", "Name"},
 			{"*ExprVariable", "Superclass"},
+			{"[]*ExprVariable", "Traits"},
+			{"[]*StmtFun", "Methods"},
+			{"[]*StmtFun", "StaticMethods"},
+			{"[]*StmtFun", "Getters"},
+			{"[]*StmtFun", "Setters"},
+		},
+	})
+
+	types = append(types, Type{
+		typename: "Trait",
+		fields: []Field{
+			{"Token", "Name"},
 			{"[]*StmtFun", "Methods"},
 		},
 	})
 
 	defineAST("Stmt", types)
+	defineCodecs("Stmt", types)
 }
@@ -2,7 +2,7 @@
 
 import os
 import sys
-from subprocess import Popen
+from runner import run
 
 def extractFile(filename):
     if not filename.endswith(".lox"):
@@ -11,11 +11,9 @@ def extractFile(filename):
     print("extracting "+filename, end="    ")
     sys.stdout.flush()
 
-    program = sys.argv[1]
     expectFile = filename.split(".lox")[0] + ".expect"
     with open(expectFile, 'w') as f:
-        p = Popen([program, filename], stdout=f, stderr=f)
-        p.communicate()
+        f.write(run(sys.argv[1], filename))
 
     print("done!")
 
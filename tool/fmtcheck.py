@@ -0,0 +1,102 @@
+#! /usr/bin/python3
+
+# Checks golox fmt on the test corpus: usage fmtcheck.py golox test
+#
+# For each file which parses, the formatted source must keep the tokens of
+# the source, print the same output, format to itself again and pass
+# fmt -check, while fmt -check must report the file if it changed.
+
+import json
+import os
+import re
+import subprocess
+import sys
+import tempfile
+
+Total = 0
+Skipped = 0
+Failed = []
+
+# the lines of the source shown under an error
+Excerpt = re.compile(rb"^ +\d+ \| .*\n *[~^]+\n", re.MULTILINE)
+
+def golox(*args, stdin=None):
+    p = subprocess.run([sys.argv[1]] + list(args), input=stdin,
+                       stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
+    return p.returncode, p.stdout.decode("utf-8")
+
+def tokens(filename):
+    _, out = golox("tokens", "-json", filename)
+    return [(t["type"], t["lexeme"]) for t in json.loads(out)]
+
+def check(filename, tmpdir):
+    code, formatted = golox("fmt", filename)
+    if code != 0:
+        return None
+
+    tmp = os.path.join(tmpdir, os.path.basename(filename))
+    with open(tmp, "w") as f:
+        f.write(formatted)
+    with open(filename) as f:
+        source = f.read()
+
+    if tokens(tmp) != tokens(filename):
+        return "the tokens changed"
+    if golox("fmt", tmp) != (0, formatted):
+        return "formatting is not idempotent"
+    if golox("fmt", "-check", tmp) != (0, ""):
+        return "fmt -check fails on the formatted file"
+    if formatted != source:
+        code, out = golox("fmt", "-check", filename)
+        if code == 0 or filename not in out:
+            return "fmt -check does not report the unformatted file"
+    # tests with a NAME.args file are run with their own arguments and input
+    if "benchmark" not in filename and not os.path.exists(filename[:-len(".lox")] + ".args"):
+        # the output of some scripts, such as printed addresses, changes
+        # from run to run
+        before, again = output(filename), output(filename)
+        if before == again and output(tmp, os.path.dirname(filename)) != before:
+            return "the output changed"
+    return ""
+
+def output(filename, cwd=None):
+    if cwd is None:
+        cwd = os.path.dirname(filename)
+        filename = os.path.basename(filename)
+    p = subprocess.run([sys.argv[1], filename], cwd=cwd,
+                       stdout=subprocess.PIPE, stderr=subprocess.DEVNULL)
+    # the source shown under errors changes with its layout
+    return Excerpt.sub(b"", p.stdout)
+
+def checkFile(filename, tmpdir):
+    if not filename.endswith(".lox"):
+        return
+
+    global Total, Skipped, Failed
+    Total += 1
+    problem = check(filename, tmpdir)
+    if problem is None:
+        Skipped += 1
+    elif problem:
+        Failed.append(filename)
+        print("=== FAIL: %s: %s" % (filename, problem))
+
+def checkDir(dirname, tmpdir):
+    root, subdirs, files = next(os.walk(dirname))
+    for f in sorted(files):
+        checkFile(os.path.join(root, f), tmpdir)
+    for d in sorted(subdirs):
+        checkDir(os.path.join(root, d), tmpdir)
+
+def main():
+    root = sys.argv[2]
+    with tempfile.TemporaryDirectory() as tmpdir:
+        if os.path.isdir(root):
+            checkDir(root, tmpdir)
+        else:
+            checkFile(root, tmpdir)
+    print("=== Total: %d Skipped: %d Failed: %d" % (Total, Skipped, len(Failed)))
+    if Failed:
+        sys.exit(1)
+
+main()
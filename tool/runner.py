@@ -0,0 +1,97 @@
+import os
+import re
+import shlex
+import shutil
+import subprocess
+import tempfile
+import threading
+
+def run(program, filename):
+    """Returns the output of the test filename, a .lox file.
+
+    A test NAME.lox is run as "program NAME.lox", unless there is a file
+    NAME.args. Each line of NAME.args is then the arguments of a golox
+    command, run in the directory of the test with {file} replaced by the
+    name of the test file and {tmp} by a temporary directory. A line may end
+    with "> path" to write the standard output of the command to path. The
+    standard input of the commands is NAME.in if it exists, and their output
+    is the output of the test. A line "--- wait TEXT" of NAME.in, with the
+    line break before it, is not written: the rest of the input is written
+    once the output of the command contains TEXT."""
+    base = filename[:-len(".lox")]
+    if not os.path.exists(base + ".args"):
+        p = subprocess.Popen([program, filename], stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
+        stdout, _ = p.communicate()
+        return decode(stdout)
+
+    program = os.path.abspath(program)
+    cwd = os.path.dirname(os.path.abspath(filename))
+    stdin = b""
+    if os.path.exists(base + ".in"):
+        with open(base + ".in", "rb") as f:
+            stdin = f.read()
+
+    tmp = tempfile.mkdtemp()
+    result = b""
+    try:
+        with open(base + ".args") as f:
+            lines = [line for line in f.read().splitlines() if line.strip()]
+        for line in lines:
+            line = line.replace("{file}", os.path.basename(filename)).replace("{tmp}", tmp)
+            args = shlex.split(line)
+            out = None
+            if len(args) >= 2 and args[-2] == ">":
+                out = args[-1]
+                args = args[:-2]
+            stdout = communicate([program] + args, cwd, stdin)
+            if out is None:
+                result += stdout
+            else:
+                with open(os.path.join(cwd, out), "wb") as f:
+                    f.write(stdout)
+    finally:
+        shutil.rmtree(tmp)
+    return decode(result)
+
+Wait = re.compile(rb"\r?\n--- wait ([^\r\n]*)\r?\n")
+
+def communicate(args, cwd, stdin):
+    """Runs args with stdin as input, paced by its wait lines, and returns
+    their output."""
+    p = subprocess.Popen(args, cwd=cwd, stdin=subprocess.PIPE,
+                         stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
+    chunks = Wait.split(stdin)
+    if len(chunks) == 1:
+        stdout, _ = p.communicate(stdin)
+        return stdout
+
+    output = []
+    read = threading.Condition()
+    def reader():
+        while True:
+            data = p.stdout.read1(4096)
+            with read:
+                output.append(data)
+                read.notify_all()
+            if not data:
+                return
+    thread = threading.Thread(target=reader)
+    thread.start()
+
+    p.stdin.write(chunks[0])
+    p.stdin.flush()
+    for n in range(1, len(chunks), 2):
+        text = chunks[n]
+        with read:
+            # an output which ended will not contain text anymore
+            read.wait_for(lambda: text in b"".join(output) or output[-1:] == [b""], 10)
+        p.stdin.write(chunks[n + 1])
+        p.stdin.flush()
+    p.stdin.close()
+    thread.join()
+    p.wait()
+    return b"".join(output)
+
+def decode(output):
+    # expect files are read with universal newlines
+    return output.decode("utf-8").replace("\r\n", "\n")
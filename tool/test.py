@@ -3,7 +3,7 @@
 import os
 import sys
 import time
-import subprocess
+from runner import run
 
 ExitOnError = False
 
@@ -28,10 +28,7 @@ def testFile(filename):
 
     start = time.time()
 
-    program = sys.argv[1]
-    p = subprocess.Popen([program, filename], stdout=subprocess.PIPE, stderr=subprocess.STDOUT)
-    stdout, _ = p.communicate()
-    result = stdout.decode("utf-8")
+    result = run(sys.argv[1], filename)
 
     expectFile = filename.split(".lox")[0] + ".expect"
     f = open(expectFile, 'r')